@@ -0,0 +1,78 @@
+package wrfhours
+
+import (
+	"sync"
+	"time"
+)
+
+// Totals are the aggregated figures
+// of the files seen by an Accumulator.
+type Totals struct {
+	Files int
+	// FilesByStream is the number of files
+	// of each stream, keyed by StreamKey.
+	FilesByStream map[string]int
+	// First and Last are the least and
+	// the greatest Instant of the files.
+	First time.Time
+	Last  time.Time
+	// Elapsed is the total time spent
+	// by WRF writing the files.
+	Elapsed time.Duration
+}
+
+// Accumulator aggregates files as they are
+// parsed, without keeping them in memory.
+// Its Add method can be registered as
+// an OnFileDo handler, e.g.
+//
+//	acc := wrfhours.NewAccumulator()
+//	parser.OnFileDo("", 0, acc.Add)
+//	err := parser.Execute()
+//	totals := acc.Result()
+//
+// It's safe for concurrent use, so it can
+// be used with ExecuteParallel too.
+type Accumulator struct {
+	lock   sync.Mutex
+	totals Totals
+}
+
+// NewAccumulator creates an empty Accumulator.
+func NewAccumulator() *Accumulator {
+	return &Accumulator{
+		totals: Totals{FilesByStream: map[string]int{}},
+	}
+}
+
+// Add adds file to the totals.
+// It never fails.
+func (acc *Accumulator) Add(file FileInfo) error {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	if acc.totals.Files == 0 || file.Instant.Before(acc.totals.First) {
+		acc.totals.First = file.Instant
+	}
+	if acc.totals.Files == 0 || file.Instant.After(acc.totals.Last) {
+		acc.totals.Last = file.Instant
+	}
+	acc.totals.Files++
+	acc.totals.FilesByStream[file.StreamKey()]++
+	acc.totals.Elapsed += file.Elapsed
+	return nil
+}
+
+// Result returns the totals of
+// the files added so far.
+func (acc *Accumulator) Result() Totals {
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	result := acc.totals
+	result.FilesByStream = make(map[string]int, len(acc.totals.FilesByStream))
+	for key, count := range acc.totals.FilesByStream {
+		result.FilesByStream[key] = count
+	}
+	return result
+}
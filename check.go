@@ -0,0 +1,44 @@
+package wrfhours
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CheckLog parses the WRF log read from r and reports whether the
+// simulation completed successfully, with a short detail meant for
+// monitoring checks. When it didn't, detail starts with the reason:
+// `timeout`, `aborted`, `format error`, `incomplete` or `error`.
+func CheckLog(r io.Reader, timeout time.Duration) (ok bool, detail string) {
+	parser := NewParser(timeout)
+	defer parser.Stop()
+	parser.SetDetectAbort(true)
+	go parser.Parse(r)
+
+	files, err := parser.Collect()
+	if err != nil {
+		return false, fmt.Sprintf("%s: %s", failureReason(err), err)
+	}
+	return true, fmt.Sprintf("completed successfully, %d files written", len(files))
+}
+
+// failureReason returns a short description
+// of the kind of error occurred.
+func failureReason(err error) string {
+	var timeoutErr *TimeoutError
+	var formatErr *FormatError
+	var abortErr *AbortError
+	switch {
+	case errors.As(err, &timeoutErr):
+		return "timeout"
+	case errors.As(err, &abortErr):
+		return "aborted"
+	case errors.As(err, &formatErr), errors.Is(err, ErrStartNotFound):
+		return "format error"
+	case errors.Is(err, ErrIncomplete):
+		return "incomplete"
+	}
+	return "error"
+}
@@ -0,0 +1,130 @@
+package wrfhours
+
+import "strings"
+
+// LineKind is the kind of a WRF log line,
+// as far as the parser is concerned.
+type LineKind int
+
+const (
+	// OtherLine is a line ignored by the parser.
+	OtherLine LineKind = iota
+	// StartLine contains the first instant of the simulation.
+	StartLine
+	// FileLine reports the writing of an output file.
+	FileLine
+	// SuccessLine signals the simulation completed successfully.
+	SuccessLine
+	// StepLine reports the time spent integrating
+	// a single time step of a domain.
+	StepLine
+	// AbortLine signals WRF aborted the simulation. The
+	// parser fails on it only when SetDetectAbort is enabled.
+	AbortLine
+)
+
+// Classifier recognizes the kind of WRF log lines.
+type Classifier interface {
+	// Classify returns the kind of line, and the text
+	// the parser should read from it. For FileLine, text
+	// must contain the part of the line following the
+	// timing prefix, e.g.
+	// `wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds`;
+	// for StartLine it must begin with the domain and instant, e.g.
+	// `d01 2021-08-04_00:00:00 ...`; for StepLine it must contain
+	// the part of the line following `Timing for main`, e.g.
+	// ` (dt=  4.00): time 2021-08-04_00:00:04 on domain   3:    1.60554 elapsed seconds`.
+	// started reports whether
+	// the start instant has already been found.
+	Classify(line string, started bool) (kind LineKind, text string)
+}
+
+const filesPrefix = "Timing for Writing "
+
+const stepsPrefix = "Timing for main"
+
+const successSuffix = "SUCCESS COMPLETE WRF"
+
+// defaultClassifier recognizes lines
+// as written by standard WRF versions.
+type defaultClassifier struct {
+	// startPrefix is the prefix of the start
+	// line, usually `d01 `.
+	startPrefix string
+	// filePrefix is the prefix of the lines
+	// reporting the writing of a file, usually
+	// `Timing for Writing `.
+	filePrefix string
+	// successSuffixes are the suffixes of the
+	// success line, usually `SUCCESS COMPLETE WRF`.
+	successSuffixes []string
+	// successLines are lines signaling the
+	// success when matched exactly.
+	successLines []string
+}
+
+// standardClassifier returns the defaultClassifier
+// with the settings of standard WRF versions.
+func standardClassifier() defaultClassifier {
+	return defaultClassifier{
+		startPrefix:     "d01 ",
+		filePrefix:      filesPrefix,
+		successSuffixes: []string{successSuffix},
+	}
+}
+
+// ClassifyLine returns the kind of a WRF log line, using
+// the same rules of a Parser with the default settings.
+// started reports whether the start instant has already
+// been found: after that, start lines are no longer
+// recognized, and are reported as OtherLine.
+func ClassifyLine(line string, started bool) LineKind {
+	kind, _ := standardClassifier().Classify(line, started)
+	return kind
+}
+
+func (c defaultClassifier) Classify(line string, started bool) (LineKind, string) {
+	// the success line starts with the domain too,
+	// so it's recognized before the start line.
+	if c.isSuccess(line) {
+		return SuccessLine, line
+	}
+
+	// first line starting with d01 contains first instant of simulation.
+	// Some captures indent it, so leading whitespace is ignored.
+	if trimmed := strings.TrimLeft(line, " \t"); !started && strings.HasPrefix(trimmed, c.startPrefix) {
+		return StartLine, trimmed
+	}
+
+	if strings.HasPrefix(line, c.filePrefix) {
+		return FileLine, strings.TrimPrefix(line, c.filePrefix)
+	}
+
+	if strings.HasPrefix(line, stepsPrefix) {
+		return StepLine, strings.TrimPrefix(line, stepsPrefix)
+	}
+
+	if strings.Contains(line, "FATAL CALLED") {
+		return AbortLine, line
+	}
+
+	return OtherLine, line
+}
+
+// isSuccess reports whether line is a success line.
+// Trailing whitespace, e.g. a carriage return, is ignored.
+func (c defaultClassifier) isSuccess(line string) bool {
+	line = strings.TrimRight(line, " \t\r")
+	for _, suffix := range c.successSuffixes {
+		if strings.HasSuffix(line, suffix) {
+			return true
+		}
+	}
+	trimmed := strings.TrimSpace(line)
+	for _, marker := range c.successLines {
+		if trimmed == marker {
+			return true
+		}
+	}
+	return false
+}
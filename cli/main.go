@@ -1,28 +1,122 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/meteocima/wrfhours"
 	"github.com/meteocima/wrfhours/json"
 )
 
 // Version of the command
 var Version string = "development"
 
+// Exit codes of the command.
+const (
+	exitOK = 0
+	// exitFailure is used for wrong usage, malformed
+	// logs and runs that aborted or didn't complete.
+	exitFailure = 1
+	exitTimeout = 2
+	// exitIO is used for errors reading
+	// the log or writing the results.
+	exitIO = 3
+)
+
 func main() {
-	showver := flag.Bool("v", false, "print version to stdout")
-	timeout := flag.Int64("t", 1, "timeout in seconds")
-	flag.Parse()
-	if showver != nil && *showver {
-		fmt.Printf("wrfhours ver. %s\n", Version)
-		os.Exit(0)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command with given arguments,
+// and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "watch":
+			return runWatch(args[1:], stdout, stderr)
+		case "verify":
+			return runVerify(stdin, stdout, stderr)
+		}
+	}
+
+	flags := flag.NewFlagSet("wrfhours", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	showver := flags.Bool("v", false, "print version to stdout")
+	timeout := timeoutFlag(time.Second)
+	flags.Var(&timeout, "t", "timeout, as a duration (e.g. 500ms, 2m) or integer seconds")
+	if err := flags.Parse(args); err != nil {
+		return exitFailure
+	}
+	if *showver {
+		fmt.Fprintf(stdout, "wrfhours ver. %s\n", Version)
+		return exitOK
+	}
+
+	if err := json.Marshal(stdin, stdout, time.Duration(timeout)); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// runVerify executes the verify command: it reads
+// the results of the command from stdin, reporting
+// the number of valid records and the first corrupt
+// line, if any.
+func runVerify(stdin io.Reader, stdout, stderr io.Writer) int {
+	count := 0
+	for file := range json.Unmarshal(stdin).Files {
+		if file.Err != nil {
+			fmt.Fprintf(stdout, "%d valid records\n", count)
+			fmt.Fprintln(stderr, file.Err.Error())
+			return exitFailure
+		}
+		count++
+	}
+	fmt.Fprintf(stdout, "%d valid records\n", count)
+	return exitOK
+}
+
+// timeoutFlag is a flag.Value accepting a duration,
+// or a bare integer number of seconds as
+// accepted by former versions of the command.
+type timeoutFlag time.Duration
+
+func (t *timeoutFlag) String() string {
+	return time.Duration(*t).String()
+}
+
+func (t *timeoutFlag) Set(value string) error {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*t = timeoutFlag(time.Duration(seconds) * time.Second)
+		return nil
 	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: expected a duration or integer seconds", value)
+	}
+	*t = timeoutFlag(d)
+	return nil
+}
 
-	if err := json.Marshal(os.Stdin, os.Stdout, time.Duration(*timeout)*time.Second); err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var timeoutErr *wrfhours.TimeoutError
+	var formatErr *wrfhours.FormatError
+	var abortErr *wrfhours.AbortError
+	switch {
+	case errors.As(err, &timeoutErr):
+		return exitTimeout
+	case errors.As(err, &formatErr),
+		errors.As(err, &abortErr),
+		errors.Is(err, wrfhours.ErrStartNotFound),
+		errors.Is(err, wrfhours.ErrIncomplete):
+		return exitFailure
 	}
+	return exitIO
 }
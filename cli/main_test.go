@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const startLine = "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n"
+
+func TestRun(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader(startLine + `Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF
+`)
+		assert.Equal(t, exitOK, run(nil, stdin, &stdout, &stderr))
+		assert.Contains(t, stdout.String(), `"Filename":"wrfout_d01_2021-08-04_00:00:00"`)
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("format error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader(startLine + "Timing for Writing auxhist23_d01_2021-08-RR_00:00:00 for domain        1:    0.10153 elapsed seconds\n")
+		assert.Equal(t, exitFailure, run(nil, stdin, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), "Wrong format for timing line")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin, w := io.Pipe()
+		defer w.Close()
+		go fmt.Fprint(w, startLine+"Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds\n")
+		assert.Equal(t, exitTimeout, run([]string{"-t", "1"}, stdin, &stdout, &stderr))
+		assert.Equal(t, "Timeout expired: no new files created for more than 1s\n", stderr.String())
+	})
+
+	t.Run("timeout as duration", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin, w := io.Pipe()
+		defer w.Close()
+		go fmt.Fprint(w, startLine+"Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds\n")
+		assert.Equal(t, exitTimeout, run([]string{"-t", "500ms"}, stdin, &stdout, &stderr))
+		assert.Equal(t, "Timeout expired: no new files created for more than 500ms\n", stderr.String())
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, exitFailure, run([]string{"-t", "soon"}, nil, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), `invalid timeout "soon": expected a duration or integer seconds`)
+	})
+
+	t.Run("I/O error", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin, w := io.Pipe()
+		w.CloseWithError(fmt.Errorf("TEST"))
+		assert.Equal(t, exitIO, run(nil, stdin, &stdout, &stderr))
+		assert.Equal(t, "TEST\n", stderr.String())
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader(`{"Type":"wrfout","Domain":1,"Filename":"wrfout_d01_2021-08-04_00:00:00"}
+{"Type":"wrfout","Domain":1,"Filename":"wrfout_d01_2021-08-04_01:00:00"}
+`)
+		assert.Equal(t, exitOK, run([]string{"verify"}, stdin, &stdout, &stderr))
+		assert.Equal(t, "2 valid records\n", stdout.String())
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("verify corrupt line", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		stdin := strings.NewReader(`{"Type":"wrfout","Domain":1,"Filename":"wrfout_d01_2021-08-04_00:00:00"}
+
+{"Type":"wrfout","Domain":1,"Filename":
+`)
+		assert.Equal(t, exitFailure, run([]string{"verify"}, stdin, &stdout, &stderr))
+		assert.Equal(t, "1 valid records\n", stdout.String())
+		assert.Equal(t, "Unmarshal failed: error while reading line 3: unexpected end of JSON input\n", stderr.String())
+	})
+
+	t.Run("version", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, exitOK, run([]string{"-v"}, nil, &stdout, &stderr))
+		assert.Equal(t, "wrfhours ver. development\n", stdout.String())
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/meteocima/wrfhours/json"
+)
+
+// watchedLog is the name of the log
+// file awaited by the watch command.
+const watchedLog = "rsl.out.0000"
+
+// tailPollInterval is the interval between reads
+// of the log when its end has been reached.
+const tailPollInterval = 100 * time.Millisecond
+
+// runWatch executes the watch command: it waits for
+// the WRF log to appear in a directory, and then follows
+// it, writing its files to stdout as they are written.
+func runWatch(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("wrfhours watch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	timeout := timeoutFlag(time.Minute)
+	flags.Var(&timeout, "t", "timeout, as a duration (e.g. 500ms, 2m) or integer seconds")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: wrfhours watch [-t timeout] dir")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitFailure
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return exitFailure
+	}
+
+	file, err := waitForLog(filepath.Join(flags.Arg(0), watchedLog))
+	if err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitIO
+	}
+	defer file.Close()
+
+	if err := json.Marshal(tailReader{file}, stdout, time.Duration(timeout)); err != nil {
+		fmt.Fprintln(stderr, err.Error())
+		return exitCode(err)
+	}
+	return exitOK
+}
+
+// waitForLog opens the file at path,
+// waiting for it to be created if needed.
+func waitForLog(path string) (*os.File, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	// the file could have been created before
+	// the watcher started.
+	if file, err := os.Open(path); err == nil {
+		return file, nil
+	}
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Op&fsnotify.Create != 0 && filepath.Clean(event.Name) == filepath.Clean(path) {
+				return os.Open(path)
+			}
+		case err := <-watcher.Errors:
+			return nil, err
+		}
+	}
+}
+
+// tailReader reads from a file being written,
+// waiting for new content when its end is reached.
+type tailReader struct {
+	file *os.File
+}
+
+func (t tailReader) Read(p []byte) (int, error) {
+	for {
+		n, err := t.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		time.Sleep(tailPollInterval)
+	}
+}
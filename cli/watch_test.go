@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+
+	var stdout, stderr syncBuffer
+	exitCode := make(chan int)
+	go func() {
+		exitCode <- run([]string{"watch", "-t", "2s", dir}, nil, &stdout, &stderr)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	log, err := os.Create(filepath.Join(dir, "rsl.out.0000"))
+	require.NoError(t, err)
+	defer log.Close()
+
+	_, err = log.WriteString(startLine + "Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds\n")
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(stdout.String(), "wrfout_d01_2021-08-04_00:00:00") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Contains(t, stdout.String(), `"Filename":"wrfout_d01_2021-08-04_00:00:00"`)
+
+	_, err = log.WriteString("d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF\n")
+	require.NoError(t, err)
+
+	select {
+	case code := <-exitCode:
+		assert.Equal(t, exitOK, code)
+		assert.Empty(t, stderr.String())
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch did not exit after the success line")
+	}
+}
@@ -0,0 +1,161 @@
+package wrfhours
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunConfig is the configuration of a WRF run,
+// as far as it can be read from its log.
+type RunConfig struct {
+	// Version is the WRF version, e.g. `V3.9.1.1`.
+	Version string
+	// Start is the start instant of the simulation.
+	Start time.Time
+	// Domains is the number of domains.
+	Domains int
+	// TimeSteps is the initial time step of each domain.
+	// It can change during the run when adaptive time
+	// steps are enabled.
+	TimeSteps map[int]time.Duration
+}
+
+// ParseConfig reads the configuration of a WRF run from the
+// header of its log, up to the first integration step of each
+// domain. Fields not found in the log are left empty; it fails
+// only when the log has no start line.
+func ParseConfig(r io.Reader) (*RunConfig, error) {
+	config := &RunConfig{TimeSteps: map[int]time.Duration{}}
+	classifier := standardClassifier()
+	started := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if version, ok := parseVersionLine(line); ok {
+			config.Version = version
+			continue
+		}
+
+		// the header contains e.g.:
+		// Timing for processing wrfinput file (stream 0) for domain        1:    0.75215 elapsed seconds
+		if strings.HasPrefix(line, "Timing for processing wrfinput file") {
+			if domain, err := parseTrailingDomain(line); err == nil && domain > config.Domains {
+				config.Domains = domain
+			}
+			continue
+		}
+
+		kind, text := classifier.Classify(line, started)
+		switch kind {
+		case StartLine:
+			fields := strings.Fields(text)
+			if len(fields) < 2 {
+				continue
+			}
+			start, err := time.Parse("2006-01-02_15:04:05", fields[1])
+			if err != nil {
+				continue
+			}
+			config.Start = start
+			started = true
+		case StepLine:
+			step, err := parseStepTiming(text)
+			if err != nil {
+				continue
+			}
+			if _, ok := config.TimeSteps[step.Domain]; !ok {
+				if dt, err := parseTimeStep(text); err == nil {
+					config.TimeSteps[step.Domain] = dt
+				}
+			}
+			if config.Domains > 0 && len(config.TimeSteps) >= config.Domains {
+				return config, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !started {
+		return nil, fmt.Errorf("start line not found")
+	}
+	return config, nil
+}
+
+// ParseVersion reads the version of WRF, e.g. `V4.3.1`,
+// from the header of its log. It stops reading at the
+// version line, and fails when the start line or the
+// end of the log is reached before it.
+func ParseVersion(r io.Reader) (string, error) {
+	classifier := standardClassifier()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if version, ok := parseVersionLine(line); ok {
+			return version, nil
+		}
+		if kind, _ := classifier.Classify(line, false); kind == StartLine {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("WRF version not found")
+}
+
+// parseVersionLine returns the version of WRF
+// if line is the header line reporting it, e.g.
+// `WRF V3.9.1.1 MODEL`.
+func parseVersionLine(line string) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 3 && fields[0] == "WRF" && fields[2] == "MODEL" {
+		return fields[1], true
+	}
+	return "", false
+}
+
+// parseTrailingDomain returns the domain of a line
+// ending with `for domain N: X elapsed seconds`. Any
+// amount of whitespace may surround N, and the colon
+// may either follow N or be separated from it.
+func parseTrailingDomain(line string) (int, error) {
+	idx := strings.Index(line, "for domain")
+	if idx == -1 {
+		return 0, fmt.Errorf("`for domain` expected to appears in line")
+	}
+	fields := strings.Fields(line[idx+len("for domain"):])
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("domain expected after `for domain`")
+	}
+	// fields[0] contains `3:`, `3`, or `3:10.02259`
+	token := strings.SplitN(fields[0], ":", 2)[0]
+	domain, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid trailing domain: %w", err)
+	}
+	return domain, nil
+}
+
+// parseTimeStep parses the time step of a step timing
+// line, e.g. ` (dt=  4.00): time ...`.
+func parseTimeStep(text string) (time.Duration, error) {
+	start := strings.Index(text, "dt=")
+	end := strings.Index(text, ")")
+	if start == -1 || end < start {
+		return 0, fmt.Errorf("`(dt=...)` expected to appears in line")
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(text[start+len("dt="):end]), 64)
+	if err != nil {
+		return 0, err
+	}
+	return secondsToDuration(seconds), nil
+}
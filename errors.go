@@ -0,0 +1,55 @@
+package wrfhours
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIncomplete is emitted when the log ends
+// without the success line.
+var ErrIncomplete = errors.New("input stream completed without success log line")
+
+// ErrStartNotFound is emitted when a timing line
+// is found before the start line.
+var ErrStartNotFound = errors.New("Start line not found yet")
+
+// TimeoutError is emitted when no file is
+// found for longer than the parser timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout expired: no new files created for more than %s", e.Timeout)
+}
+
+// FormatError is emitted when a start or timing
+// line has an unexpected format.
+type FormatError struct {
+	// Kind is the kind of line, `timing`
+	// or `start instant`.
+	Kind string
+	// Line is the malformed line.
+	Line string
+	Err  error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("Wrong format for %s line `%s`: %s", e.Kind, e.Line, e.Err)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// AbortError is emitted when the log
+// reports WRF aborted the simulation.
+type AbortError struct {
+	// Line is the line reporting the abort.
+	Line string
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("WRF aborted: `%s`", e.Line)
+}
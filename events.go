@@ -0,0 +1,93 @@
+package wrfhours
+
+import "time"
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// StartEvent is emitted when the start
+	// instant of the simulation is known.
+	StartEvent EventKind = iota
+	// FileEvent is emitted for each file parsed.
+	FileEvent
+	// SuccessEvent is emitted when the
+	// simulation completed successfully.
+	SuccessEvent
+	// ErrorEvent is emitted when parsing fails.
+	ErrorEvent
+)
+
+// Event is a single step of the parsing of a WRF log.
+// Only the field relevant to the Kind of event is set.
+type Event struct {
+	Kind EventKind
+	// Start is the start instant of the simulation,
+	// for StartEvent.
+	Start time.Time
+	// File is the parsed file, for FileEvent.
+	File FileInfo
+	// Err is the error occurred, for ErrorEvent.
+	Err error
+}
+
+// Events returns a channel emitting the events of the
+// parse: a StartEvent, a FileEvent for each file and then
+// either a SuccessEvent or an ErrorEvent. The StartEvent
+// is emitted before the first file, or before the
+// ErrorEvent or the end of the stream when no files were
+// found. The channel is fed by reading the Files channel,
+// so the caller must not read Files too.
+// The channel is closed after the last event.
+func (parser *Parser) Events() <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		emit := func(event Event) bool {
+			select {
+			case events <- event:
+				return true
+			case <-parser.done:
+				return false
+			}
+		}
+
+		started := false
+		emitStart := func() bool {
+			parser.lock.Lock()
+			start := parser.Start
+			parser.lock.Unlock()
+			if started || start == nil {
+				return true
+			}
+			started = true
+			return emit(Event{Kind: StartEvent, Start: *start})
+		}
+
+		for file := range parser.Files {
+			if file.Err != nil {
+				if emitStart() {
+					emit(Event{Kind: ErrorEvent, Err: file.Err})
+				}
+				return
+			}
+			if file.Done {
+				continue
+			}
+			if !emitStart() || !emit(Event{Kind: FileEvent, File: file}) {
+				return
+			}
+		}
+
+		if !emitStart() {
+			return
+		}
+		if parser.CompletedSuccessfully() {
+			emit(Event{Kind: SuccessEvent})
+		}
+	}()
+
+	return events
+}
@@ -0,0 +1,187 @@
+package wrfhours
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Path returns the path of the file, given
+// the directory WRF was run into.
+func (f FileInfo) Path(dir string) string {
+	return filepath.Join(dir, f.Filename)
+}
+
+// RelPath returns the path of the file relative to
+// the directory WRF was run into. It's the Filename,
+// unless the parser had a mapper set with
+// Parser.SetPathMapper.
+func (f FileInfo) RelPath() string {
+	if f.relPath != "" {
+		return f.relPath
+	}
+	return f.Filename
+}
+
+// StreamKey returns the key identifying the stream
+// the file belongs to, in the form `type/dNN`,
+// e.g. `wrfout/d03`.
+func (f FileInfo) StreamKey() string {
+	return fmt.Sprintf("%s/d%02d", f.Type, f.Domain)
+}
+
+// LatestPerStream returns, for each stream of files
+// (see FileInfo.StreamKey), the file with the greatest Instant.
+func LatestPerStream(files []FileInfo) map[string]FileInfo {
+	latest := map[string]FileInfo{}
+	for _, f := range files {
+		key := f.StreamKey()
+		if current, ok := latest[key]; !ok || f.Instant.After(current.Instant) {
+			latest[key] = f
+		}
+	}
+	return latest
+}
+
+// TimelineEntry is a single file of a Timeline.
+type TimelineEntry struct {
+	Instant   time.Time
+	Type      string
+	Domain    int
+	HourProgr int
+	// CumulativeCount is the number of files
+	// in the timeline up to this entry, included.
+	CumulativeCount int
+}
+
+// Timeline returns the files sorted by Instant,
+// together with the cumulative count of files.
+// Files with the same Instant keep their
+// original relative order.
+func Timeline(files []FileInfo) []TimelineEntry {
+	sorted := make([]FileInfo, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Instant.Before(sorted[j].Instant)
+	})
+
+	timeline := make([]TimelineEntry, len(sorted))
+	for i, f := range sorted {
+		timeline[i] = TimelineEntry{
+			Instant:         f.Instant,
+			Type:            f.Type,
+			Domain:          f.Domain,
+			HourProgr:       f.HourProgr,
+			CumulativeCount: i + 1,
+		}
+	}
+	return timeline
+}
+
+// WriteTimeByDomain returns, for each domain, the
+// total time WRF spent writing the files.
+func WriteTimeByDomain(files []FileInfo) map[int]time.Duration {
+	byDomain := map[int]time.Duration{}
+	for _, f := range files {
+		byDomain[f.Domain] += f.Elapsed
+	}
+	return byDomain
+}
+
+// DiffRuns compares the files written by two runs by
+// Filename, returning the files written only by run a
+// and the ones written only by run b, in their order.
+func DiffRuns(a, b []FileInfo) (onlyA, onlyB []FileInfo) {
+	return filesMissingFrom(a, b), filesMissingFrom(b, a)
+}
+
+// filesMissingFrom returns the files of
+// files whose Filename is not in other.
+func filesMissingFrom(files, other []FileInfo) []FileInfo {
+	names := map[string]bool{}
+	for _, f := range other {
+		names[f.Filename] = true
+	}
+	missing := []FileInfo{}
+	for _, f := range files {
+		if !names[f.Filename] {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+// ElapsedRegressions compares the write times of the files of two
+// runs, matching them by Filename, and returns a message for each
+// file of current whose Elapsed exceeds the one of baseline
+// multiplied by factor, e.g. `wrfout_d01_2021-08-04_00:00:00 took
+// 2s, more than 1.5 times the 1s of baseline`. Files missing from
+// either run are ignored.
+func ElapsedRegressions(baseline, current []FileInfo, factor float64) []string {
+	elapsed := map[string]time.Duration{}
+	for _, f := range baseline {
+		elapsed[f.Filename] = f.Elapsed
+	}
+
+	regressions := []string{}
+	for _, f := range current {
+		base, ok := elapsed[f.Filename]
+		if !ok {
+			continue
+		}
+		if float64(f.Elapsed) > float64(base)*factor {
+			regressions = append(regressions, fmt.Sprintf(
+				"%s took %s, more than %g times the %s of baseline",
+				f.Filename, f.Elapsed, factor, base,
+			))
+		}
+	}
+	return regressions
+}
+
+// FirstFileLatency returns, for each domain, the distance
+// of the Instant of its first file from start.
+func FirstFileLatency(start time.Time, files []FileInfo) map[int]time.Duration {
+	first := map[int]time.Time{}
+	for _, f := range files {
+		if current, ok := first[f.Domain]; !ok || f.Instant.Before(current) {
+			first[f.Domain] = f.Instant
+		}
+	}
+
+	latency := make(map[int]time.Duration, len(first))
+	for domain, instant := range first {
+		latency[domain] = instant.Sub(start)
+	}
+	return latency
+}
+
+// CheckMatrix returns a message for each combination of
+// type and domain in expected for which files contains no
+// file, e.g. `no auxhist23 files written for domain 3`.
+// Types are reported in alphabetical order, domains in
+// the order they appear in expected.
+func CheckMatrix(files []FileInfo, expected map[string][]int) []string {
+	written := map[string]bool{}
+	for _, f := range files {
+		written[f.StreamKey()] = true
+	}
+
+	types := make([]string, 0, len(expected))
+	for typ := range expected {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	missing := []string{}
+	for _, typ := range types {
+		for _, domain := range expected[typ] {
+			key := FileInfo{Type: typ, Domain: domain}.StreamKey()
+			if !written[key] {
+				missing = append(missing, fmt.Sprintf("no %s files written for domain %d", typ, domain))
+			}
+		}
+	}
+	return missing
+}
@@ -0,0 +1,62 @@
+package wrfhours
+
+import "fmt"
+
+// Filter selects files by type, domain and
+// hour. Zero valued fields match any file.
+type Filter struct {
+	Type   string
+	Domain int
+	// HourFrom and HourTo, when HourTo is
+	// greater than 0, restrict matches to
+	// files with HourFrom <= HourProgr < HourTo.
+	HourFrom int
+	HourTo   int
+	// BaseType enables comparing Type with the BaseType
+	// of files, so that e.g. `auxhist` matches both
+	// `auxhist2` and `auxhist23` files.
+	BaseType bool
+}
+
+// String returns a representation of the filter
+// listing its fields, e.g. `{Type:wrfout Domain:3 HourFrom:0 HourTo:0}`.
+// BaseType is listed only when set.
+func (filter Filter) String() string {
+	s := fmt.Sprintf("{Type:%s Domain:%d HourFrom:%d HourTo:%d", filter.Type, filter.Domain, filter.HourFrom, filter.HourTo)
+	if filter.BaseType {
+		s += " BaseType:true"
+	}
+	return s + "}"
+}
+
+// Matches returns whether the file is selected by the filter.
+func (filter Filter) Matches(file FileInfo) bool {
+	if filter.Type != "" {
+		typ := file.Type
+		if filter.BaseType {
+			typ = BaseType(typ)
+		}
+		if filter.Type != typ {
+			return false
+		}
+	}
+	if filter.Domain != 0 && filter.Domain != file.Domain {
+		return false
+	}
+	if filter.HourTo > 0 && (file.HourProgr < filter.HourFrom || file.HourProgr >= filter.HourTo) {
+		return false
+	}
+	return true
+}
+
+// FilterFiles returns the files matched by filter,
+// in the same order they appear in files.
+func FilterFiles(files []FileInfo, filter Filter) []FileInfo {
+	matches := []FileInfo{}
+	for _, file := range files {
+		if filter.Matches(file) {
+			matches = append(matches, file)
+		}
+	}
+	return matches
+}
@@ -2,4 +2,8 @@ module github.com/meteocima/wrfhours
 
 go 1.16
 
-require github.com/stretchr/testify v1.7.0
+require (
+	github.com/fsnotify/fsnotify v1.5.1
+	github.com/klauspost/compress v1.15.9
+	github.com/stretchr/testify v1.7.0
+)
@@ -0,0 +1,57 @@
+package helpers
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// openMaybeCompressed returns a reader decompressing rc when
+// name has the extension of a supported compression format:
+// `.gz`, `.bz2` or `.zst`. Otherwise it returns rc as is.
+// Closing the returned reader closes rc too.
+func openMaybeCompressed(rc io.ReadCloser, name string) (io.ReadCloser, error) {
+	switch path.Ext(name) {
+	case ".gz":
+		r, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return &chainedCloser{Reader: r, closers: []func() error{r.Close, rc.Close}}, nil
+	case ".bz2":
+		return &chainedCloser{Reader: bzip2.NewReader(rc), closers: []func() error{rc.Close}}, nil
+	case ".zst":
+		r, err := zstd.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		closeDecoder := func() error {
+			r.Close()
+			return nil
+		}
+		return &chainedCloser{Reader: r, closers: []func() error{closeDecoder, rc.Close}}, nil
+	}
+	return rc, nil
+}
+
+// chainedCloser is a reader that, when closed,
+// calls all closers, returning the first error.
+type chainedCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (c *chainedCloser) Close() error {
+	var err error
+	for _, closer := range c.closers {
+		if e := closer(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -14,11 +14,14 @@ var fixtureFS, _ = fs.Sub(fixtureRootFS, "fixtures")
 
 // This example parse an existing WRF log file,
 // and print the first two output files found there.
+// Since it stops reading before the end of the stream,
+// it calls Stop to let the parser goroutines exit.
 func ExampleParseFile() {
 	parser, err := helpers.ParseFile(fixtureFS, "rsl.out.0000")
 	if err != nil {
 		panic(err)
 	}
+	defer parser.Stop()
 	i := 0
 	for f := range parser.Files {
 		fmt.Println(f.HourProgr, f.Type, f.Instant)
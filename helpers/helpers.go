@@ -1,27 +1,385 @@
 package helpers
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/meteocima/wrfhours"
 )
 
-// ParseFile parse WRF log from a given file.
+// ParseFile parse WRF log from a given file. Logs
+// compressed with gzip, bzip2 or zstd are decompressed,
+// according to the extension of wrfLogPath.
 func ParseFile(fs fs.FS, wrfLogPath string) (*wrfhours.Parser, error) {
+	return ParseFileTimeout(fs, wrfLogPath, 100*time.Millisecond)
+}
+
+// ParseFileTimeout works like ParseFile, but with the given
+// timeout, e.g. a longer one for logs that are slow to read,
+// like compressed logs of long runs.
+func ParseFileTimeout(fs fs.FS, wrfLogPath string, timeout time.Duration) (*wrfhours.Parser, error) {
 
-	file, err := fs.Open(wrfLogPath)
+	file, err := openFile(fs, wrfLogPath)
 	if err != nil {
 		return nil, err
 	}
 
-	res := Parse(file, 100*time.Millisecond)
+	res := Parse(file, timeout)
 	res.SetOnClose(file.Close)
 
 	return res, nil
 }
 
+// ParseFiles summarizes the WRF logs at paths, parsing up to
+// workers of them concurrently. It returns the summary of each
+// log that could be opened, also when its parse failed, and a
+// *BatchError reporting the errors of the failed logs, if any.
+func ParseFiles(fsys fs.FS, paths []string, workers int) (map[string]*wrfhours.RunSummary, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var lock sync.Mutex
+	summaries := map[string]*wrfhours.RunSummary{}
+	errs := map[string]error{}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				summary, err := summarizeFile(fsys, path)
+				lock.Lock()
+				if summary != nil {
+					summaries[path] = summary
+				}
+				if err != nil {
+					errs[path] = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return summaries, &BatchError{Errs: errs}
+	}
+	return summaries, nil
+}
+
+// summarizeFile summarizes the WRF log at path.
+func summarizeFile(fsys fs.FS, path string) (*wrfhours.RunSummary, error) {
+	file, err := openFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// the log is already complete, so the timeout
+	// only guards against stalled reads.
+	return wrfhours.Summarize(file, time.Minute)
+}
+
+// BatchError is returned by ParseFiles
+// when some of the logs failed.
+type BatchError struct {
+	// Errs contains the error of each failed log.
+	Errs map[string]error
+}
+
+func (e *BatchError) Error() string {
+	paths := make([]string, 0, len(e.Errs))
+	for path := range e.Errs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	msgs := make([]string, len(paths))
+	for i, path := range paths {
+		msgs[i] = fmt.Sprintf("%s: %s", path, e.Errs[path])
+	}
+	return fmt.Sprintf("%d logs failed: %s", len(paths), strings.Join(msgs, "; "))
+}
+
+// openFile opens the file at path,
+// decompressing it if needed.
+func openFile(fsys fs.FS, path string) (io.ReadCloser, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return openMaybeCompressed(file, path)
+}
+
+// ParseRotated parse WRF log split across rotated files,
+// e.g. rsl.out.0000, rsl.out.0000.1, reading them in the
+// given order as a single stream. Compressed files are
+// decompressed as in ParseFile. Each file must end with
+// a newline, otherwise its last line is joined with the
+// first line of the next one. All files are closed when
+// the parse completes.
+func ParseRotated(fs fs.FS, paths []string, timeout time.Duration) (*wrfhours.Parser, error) {
+	files := make([]io.ReadCloser, 0, len(paths))
+	closeAll := func() error {
+		var err error
+		for _, file := range files {
+			if e := file.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}
+
+	for _, path := range paths {
+		file, err := openFile(fs, path)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	readers := make([]io.Reader, len(files))
+	for i, file := range files {
+		readers[i] = file
+	}
+
+	res := wrfhours.NewParser(timeout)
+	res.SetOnClose(closeAll)
+
+	go res.Parse(io.MultiReader(readers...))
+
+	return res, nil
+}
+
+// ParseMerged parse a WRF log split across several readers,
+// e.g. rsl.out.0000 and rsl.error.0000 in I/O quilting setups,
+// merging their lines in arrival order. Timing and success lines
+// are held back until one of the readers produces the standard
+// `d01 ` start line, or until all other readers are exhausted
+// or holding lines too.
+func ParseMerged(timeout time.Duration, readers ...io.Reader) *wrfhours.Parser {
+	r, w := io.Pipe()
+	m := &merger{out: w, active: len(readers)}
+	m.cond = sync.NewCond(&m.lock)
+
+	var wg sync.WaitGroup
+	wg.Add(len(readers))
+	for _, reader := range readers {
+		go func(reader io.Reader) {
+			defer wg.Done()
+			m.copyLines(reader)
+		}(reader)
+	}
+	go func() {
+		wg.Wait()
+		w.CloseWithError(m.err)
+	}()
+
+	return parsePipe(wrfhours.NewParser(timeout), r)
+}
+
+// parsePipe starts parser on the log read from r, closing r when
+// the parse ends, e.g. on the success line, so that the
+// goroutines still writing lines to the pipe fail
+// instead of blocking forever.
+func parsePipe(parser *wrfhours.Parser, r *io.PipeReader) *wrfhours.Parser {
+	parser.SetOnClose(func() error {
+		r.Close()
+		return nil
+	})
+
+	go parser.Parse(r)
+
+	return parser
+}
+
+// ParseStdStreams parse a WRF log whose standard output and
+// standard error have been captured separately. All the lines
+// of stdout are parsed, while stderr is only watched for the
+// `FATAL CALLED` lines WRF writes when it aborts, which make
+// the parse fail with an *AbortError (see SetDetectAbort).
+func ParseStdStreams(stdout, stderr io.Reader, timeout time.Duration) *wrfhours.Parser {
+	r, w := io.Pipe()
+	var (
+		lock     sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	copyLines := func(r io.Reader, keep func(line string) bool) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !keep(line) {
+				continue
+			}
+			// the pipe serializes concurrent writes.
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				// the parse has been interrupted.
+				return
+			}
+		}
+		lock.Lock()
+		if err := scanner.Err(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		lock.Unlock()
+	}
+
+	wg.Add(2)
+	go copyLines(stdout, func(string) bool { return true })
+	go copyLines(stderr, func(line string) bool {
+		return wrfhours.ClassifyLine(line, true) == wrfhours.AbortLine
+	})
+	go func() {
+		wg.Wait()
+		w.CloseWithError(firstErr)
+	}()
+
+	parser := wrfhours.NewParser(timeout)
+	parser.SetDetectAbort(true)
+	return parsePipe(parser, r)
+}
+
+// merger writes lines read from several readers to out.
+type merger struct {
+	out  *io.PipeWriter
+	lock sync.Mutex
+	cond *sync.Cond
+	// started reports whether the start line has been written.
+	started bool
+	// active is the number of readers
+	// neither exhausted nor holding a line.
+	active int
+	// err is the first error occurred reading lines.
+	err error
+}
+
+func (m *merger) copyLines(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if isHeldLine(line) {
+			m.lock.Lock()
+			if !m.started {
+				m.active--
+				m.cond.Broadcast()
+				for !m.started && m.active > 0 {
+					m.cond.Wait()
+				}
+				m.active++
+			}
+			m.lock.Unlock()
+		}
+
+		// the pipe serializes concurrent writes,
+		// so the lock is not held while writing.
+		if _, err := io.WriteString(m.out, line+"\n"); err != nil {
+			// the parse has been interrupted.
+			break
+		}
+
+		// held lines are released only once the
+		// start line has actually been written.
+		if wrfhours.ClassifyLine(line, false) == wrfhours.StartLine {
+			m.lock.Lock()
+			m.started = true
+			m.cond.Broadcast()
+			m.lock.Unlock()
+		}
+	}
+
+	m.lock.Lock()
+	if err := scanner.Err(); err != nil && m.err == nil {
+		m.err = err
+	}
+	m.active--
+	m.cond.Broadcast()
+	m.lock.Unlock()
+}
+
+// isHeldLine reports whether line must be
+// written only after the start line.
+func isHeldLine(line string) bool {
+	return strings.HasPrefix(line, "Timing for Writing ") ||
+		strings.HasSuffix(line, "SUCCESS COMPLETE WRF")
+}
+
+// ParseFrom parse a WRF log of given size starting from
+// approxOffset, to skip the files written before it.
+// See Parser.ParseFrom.
+func ParseFrom(ra io.ReaderAt, size int64, approxOffset int64, timeout time.Duration) *wrfhours.Parser {
+	parser := wrfhours.NewParser(timeout)
+
+	go parser.ParseFrom(ra, size, approxOffset)
+
+	return parser
+}
+
+// ParseFileWithStart parse WRF log from a given file, like ParseFile,
+// and additionally returns the start instant of the simulation.
+// To do so, the file is read twice: a first time until the start
+// instant is found, and a second time to parse its files.
+func ParseFileWithStart(fs fs.FS, wrfLogPath string) (*wrfhours.Parser, time.Time, error) {
+	start, err := findStart(fs, wrfLogPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	res, err := ParseFile(fs, wrfLogPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return res, start, nil
+}
+
+// findStart reads a WRF log until its start line is found, and
+// parses the start instant from it. Since the rest of the log
+// isn't parsed, a malformed line following the start line
+// doesn't fail it. Like the Parser, it fails on timing lines
+// preceding the start line.
+func findStart(fs fs.FS, wrfLogPath string) (time.Time, error) {
+	file, err := openFile(fs, wrfLogPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch wrfhours.ClassifyLine(line, false) {
+		case wrfhours.StartLine:
+			return wrfhours.ParseStartLine(line)
+		case wrfhours.FileLine:
+			return time.Time{}, fmt.Errorf("%w: timing line %d `%s` precedes it", wrfhours.ErrStartNotFound, lineNum, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Time{}, fmt.Errorf("start instant not found in %s", wrfLogPath)
+}
+
 // Parse parse WRF log from a given file.
 func Parse(r io.Reader, timeout time.Duration) *wrfhours.Parser {
 	parser := wrfhours.NewParser(timeout)
@@ -30,3 +388,71 @@ func Parse(r io.Reader, timeout time.Duration) *wrfhours.Parser {
 
 	return parser
 }
+
+// ParseLines parse WRF log lines received from a channel,
+// until it's closed.
+func ParseLines(lines <-chan string, timeout time.Duration) *wrfhours.Parser {
+	parser := wrfhours.NewParser(timeout)
+
+	go parser.ParseLines(lines)
+
+	return parser
+}
+
+// parseWriter is the io.WriteCloser returned by NewParseWriter.
+type parseWriter struct {
+	pipe *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// NewParseWriter returns a writer that parses the WRF log
+// written to it, calling fn for each file found. It can be used
+// e.g. as the Stdout of an exec.Cmd. Writes block until the
+// written data has been parsed; once the parse completes, further
+// data is discarded, and when it fails, writes return the error.
+// Close must be called at the end of the log, and returns
+// the error of the parse, if any.
+func NewParseWriter(timeout time.Duration, fn func(wrfhours.FileInfo) error) io.WriteCloser {
+	r, w := io.Pipe()
+	pw := &parseWriter{
+		pipe: w,
+		done: make(chan struct{}),
+	}
+
+	parser := wrfhours.NewParser(timeout)
+	parser.OnFilePredicate(func(wrfhours.FileInfo) bool { return true }, fn)
+	go parser.Parse(r)
+
+	go func() {
+		defer close(pw.done)
+		if pw.err = parser.Execute(); pw.err != nil {
+			parser.Stop()
+			r.CloseWithError(pw.err)
+			return
+		}
+		// the parse is completed, discard the rest of the log.
+		io.Copy(io.Discard, r)
+	}()
+
+	return pw
+}
+
+func (pw *parseWriter) Write(p []byte) (int, error) {
+	return pw.pipe.Write(p)
+}
+
+func (pw *parseWriter) Close() error {
+	pw.pipe.Close()
+	<-pw.done
+	return pw.err
+}
+
+// ParseBytes parse WRF log from an in-memory buffer.
+// The buffer may begin with a partial line, as happens
+// when it holds the tail of a log kept in a ring buffer:
+// the parser skips lines that are not start, timing or success lines,
+// so a truncated leading line is usually tolerated.
+func ParseBytes(data []byte, timeout time.Duration) *wrfhours.Parser {
+	return Parse(bytes.NewReader(data), timeout)
+}
@@ -1,12 +1,20 @@
 package helpers
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -80,31 +88,37 @@ func TestParseFile(t *testing.T) {
 		assert.Equal(t, 1, len(actualD1))
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "wrfout",
-			Domain:    1,
-			Instant:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
-			Filename:  "wrfout_d01_2021-08-04_00:00:00",
-			HourProgr: 0,
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_00:00:00",
+			HourProgr:      0,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
 		}, actualD1[0])
 
 		assert.Equal(t, 49, len(actualD3))
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "wrfout",
-			Domain:    3,
-			Instant:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
-			Filename:  "wrfout_d03_2021-08-04_00:00:00",
-			HourProgr: 0,
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_00:00:00",
+			HourProgr:      0,
+			Elapsed:        928150 * time.Microsecond,
+			ElapsedSeconds: 0.92815,
 		}, actualD3[0])
 
 		//Timing for Writing wrfout_d03_2021-08-04_08:00:00 for domain        3:    0.88979 elapsed seconds
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "wrfout",
-			Domain:    3,
-			Instant:   time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
-			Filename:  "wrfout_d03_2021-08-04_10:00:00",
-			HourProgr: 10,
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_10:00:00",
+			HourProgr:      10,
+			Elapsed:        887110 * time.Microsecond,
+			ElapsedSeconds: 0.88711,
 		}, actualD3[10])
 
 	})
@@ -125,6 +139,37 @@ func TestParseFile(t *testing.T) {
 		assert.EqualError(t, err, "input stream completed without success log line")
 	})
 
+	t.Run("heartbeat while waiting for files", func(t *testing.T) {
+		r, w := io.Pipe()
+
+		go func() {
+			fmt.Fprintln(w, "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated")
+			fmt.Fprintln(w, "Timing for Writing auxhist23_d01_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds")
+			time.Sleep(100 * time.Millisecond)
+			fmt.Fprintln(w, "Timing for Writing auxhist23_d01_2021-08-06_01:00:00 for domain        1:    0.10153 elapsed seconds")
+			fmt.Fprintln(w, successLine)
+			w.Close()
+		}()
+
+		parser := wrfhours.NewParser(time.Second)
+		var lock sync.Mutex
+		beats := 0
+		parser.SetHeartbeat(20*time.Millisecond, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			beats++
+		})
+		go parser.Parse(r)
+
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(actual))
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.GreaterOrEqual(t, beats, 1)
+	})
+
 	t.Run("parse stream with pauses", func(t *testing.T) {
 		r, w := io.Pipe()
 
@@ -146,11 +191,13 @@ func TestParseFile(t *testing.T) {
 		assert.Equal(t, 1, len(actual))
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "auxhist23",
-			Domain:    1,
-			Instant:   time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
-			Filename:  "auxhist23_d01_2021-08-06_00:00:00",
-			HourProgr: 48,
+			Type:           "auxhist23",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
+			Filename:       "auxhist23_d01_2021-08-06_00:00:00",
+			HourProgr:      48,
+			Elapsed:        101530 * time.Microsecond,
+			ElapsedSeconds: 0.10153,
 		}, actual[0])
 	})
 
@@ -175,7 +222,7 @@ SUCCESS COMPLETE WRF
 		require.NoError(t, err)
 		actual, err := results.Collect()
 		assert.Nil(t, actual)
-		assert.EqualError(t, err, "Start line not found yet")
+		assert.EqualError(t, err, "Start line not found yet: timing line 1 `Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds` precedes it")
 	})
 
 	t.Run("emit error on wrong number of filename parts", func(t *testing.T) {
@@ -231,6 +278,222 @@ SUCCESS COMPLETE WRF
 
 	})
 
+	t.Run("OnFileDo with handler stopping the parse", func(t *testing.T) {
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		var actual []wrfhours.FileInfo
+
+		err = results.OnFileDo("", 0, func(file wrfhours.FileInfo) error {
+			actual = append(actual, file)
+			if len(actual) == 5 {
+				return wrfhours.ErrStopParsing
+			}
+			return nil
+		}).Execute()
+
+		require.NoError(t, err)
+		assert.Equal(t, 5, len(actual))
+	})
+
+	t.Run("OnFilePredicate", func(t *testing.T) {
+
+		evenHoursOf := func(domain int) func(file wrfhours.FileInfo) bool {
+			return func(file wrfhours.FileInfo) bool {
+				return file.Domain == domain && file.HourProgr%2 == 0
+			}
+		}
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var actualD2 []wrfhours.FileInfo
+		var actualD3 []wrfhours.FileInfo
+
+		err = results.OnFilePredicate(evenHoursOf(2), func(file wrfhours.FileInfo) error {
+			actualD2 = append(actualD2, file)
+			return nil
+		}).OnFilePredicate(evenHoursOf(3), func(file wrfhours.FileInfo) error {
+			actualD3 = append(actualD3, file)
+			return nil
+		}).Execute()
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, len(actualD2))
+		assert.Equal(t, 75, len(actualD3))
+		for _, file := range append(actualD2, actualD3...) {
+			assert.Equal(t, 0, file.HourProgr%2)
+		}
+	})
+
+	t.Run("ExecuteParallel", func(t *testing.T) {
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var lock sync.Mutex
+		var actual []wrfhours.FileInfo
+
+		started := time.Now()
+		err = results.OnFileDo("wrfout", 3, func(file wrfhours.FileInfo) error {
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			actual = append(actual, file)
+			return nil
+		}).ExecuteParallel(8)
+		elapsed := time.Since(started)
+
+		require.NoError(t, err)
+		assert.Equal(t, 49, len(actual))
+		// serially, it would take 49 * 20ms
+		assert.Less(t, int64(elapsed), int64(500*time.Millisecond))
+	})
+
+	t.Run("ExecuteParallel with failing handler", func(t *testing.T) {
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var lock sync.Mutex
+		running := 0
+		finished := 0
+
+		err = results.OnFileDo("", 0, func(file wrfhours.FileInfo) error {
+			lock.Lock()
+			running++
+			lock.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			lock.Lock()
+			defer lock.Unlock()
+			finished++
+			if file.Domain == 3 {
+				return fmt.Errorf("TEST")
+			}
+			return nil
+		}).ExecuteParallel(4)
+
+		assert.EqualError(t, err, "OnFileDo handler failed: TEST")
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, running, finished)
+		assert.Less(t, finished, 201)
+	})
+
+	t.Run("NewParseWriter", func(t *testing.T) {
+		data, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var actual []wrfhours.FileInfo
+		w := NewParseWriter(100*time.Millisecond, func(file wrfhours.FileInfo) error {
+			actual = append(actual, file)
+			return nil
+		})
+		for len(data) > 0 {
+			chunk := 1000
+			if chunk > len(data) {
+				chunk = len(data)
+			}
+			_, err := w.Write(data[:chunk])
+			require.NoError(t, err)
+			data = data[chunk:]
+		}
+		require.NoError(t, w.Close())
+
+		checkResults(t, actual)
+	})
+
+	t.Run("NewParseWriter without success line", func(t *testing.T) {
+		w := NewParseWriter(100*time.Millisecond, func(file wrfhours.FileInfo) error {
+			return nil
+		})
+		_, err := io.WriteString(w, "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n")
+		require.NoError(t, err)
+		assert.EqualError(t, w.Close(), "input stream completed without success log line")
+	})
+
+	t.Run("Accumulator", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		acc := wrfhours.NewAccumulator()
+		results.OnFileDo("", 0, acc.Add)
+		require.NoError(t, results.Execute())
+
+		totals := acc.Result()
+		assert.Equal(t, 201, totals.Files)
+		assert.Equal(t, map[string]int{
+			"wrfout/d01":    1,
+			"wrfout/d02":    1,
+			"wrfout/d03":    49,
+			"auxhist2/d01":  1,
+			"auxhist2/d02":  1,
+			"auxhist2/d03":  49,
+			"auxhist23/d01": 49,
+			"auxhist23/d02": 1,
+			"auxhist23/d03": 49,
+		}, totals.FilesByStream)
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), totals.First)
+		assert.Equal(t, time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC), totals.Last)
+		assert.True(t, totals.Elapsed > 0)
+	})
+
+	t.Run("OnHourComplete", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		// hour 0 has 9 files, the others 4.
+		seen := map[int]int{}
+		hours := []int{}
+		err = results.OnFilePredicate(func(wrfhours.FileInfo) bool { return true }, func(info wrfhours.FileInfo) error {
+			seen[info.HourProgr]++
+			return nil
+		}).OnHourComplete(9, func(hour int, files []wrfhours.FileInfo) error {
+			hours = append(hours, hour)
+			assert.Equal(t, 9, seen[hour], "called before the last file of the hour")
+			assert.Equal(t, 9, len(files))
+			for _, f := range files {
+				assert.Equal(t, hour, f.HourProgr)
+			}
+			return nil
+		}).Execute()
+		require.NoError(t, err)
+
+		assert.Equal(t, []int{0}, hours)
+		assert.Equal(t, 9, seen[0])
+		assert.Equal(t, 4, seen[1])
+	})
+
+	t.Run("SetGroupByBaseType", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		grouped := map[string]int{}
+		results.OnFileDo(wrfhours.TypeAuxhist, 0, func(info wrfhours.FileInfo) error {
+			grouped[info.Type]++
+			return nil
+		})
+		// it affects the handlers registered before it too.
+		results.SetGroupByBaseType(true)
+		require.NoError(t, results.Execute())
+
+		assert.Equal(t, map[string]int{"auxhist2": 51, "auxhist23": 99}, grouped)
+	})
+
+	t.Run("Filter by base type", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		assert.Empty(t, wrfhours.FilterFiles(actual, wrfhours.Filter{Type: "auxhist"}))
+		assert.Equal(t, 150, len(wrfhours.FilterFiles(actual, wrfhours.Filter{Type: "auxhist", BaseType: true})))
+		assert.Equal(t, 50, len(wrfhours.FilterFiles(actual, wrfhours.Filter{Type: "auxhist", Domain: 1, BaseType: true})))
+		assert.Equal(t, "auxhist", wrfhours.BaseType("auxhist23"))
+		assert.Equal(t, "wrfout", wrfhours.BaseType("wrfout"))
+	})
+
 	t.Run("OnFileDo complete file", func(t *testing.T) {
 
 		results, err := ParseFile(fixtureFS, "rsl.out.0000")
@@ -264,68 +527,2038 @@ SUCCESS COMPLETE WRF
 		assert.Equal(t, 49, len(actual))
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "wrfout",
-			Domain:    3,
-			Instant:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
-			Filename:  "wrfout_d03_2021-08-04_00:00:00",
-			HourProgr: 0,
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_00:00:00",
+			HourProgr:      0,
+			Elapsed:        928150 * time.Microsecond,
+			ElapsedSeconds: 0.92815,
 		}, actual[0])
 
 		//Timing for Writing wrfout_d03_2021-08-04_08:00:00 for domain        3:    0.88979 elapsed seconds
 
 		assert.Equal(t, wrfhours.FileInfo{
-			Type:      "wrfout",
-			Domain:    3,
-			Instant:   time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
-			Filename:  "wrfout_d03_2021-08-04_10:00:00",
-			HourProgr: 10,
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_10:00:00",
+			HourProgr:      10,
+			Elapsed:        887110 * time.Microsecond,
+			ElapsedSeconds: 0.88711,
 		}, actual[10])
 
 	})
 
-	t.Run("Collect complete file", func(t *testing.T) {
+	t.Run("record discovery time", func(t *testing.T) {
+		before := time.Now()
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetRecordDiscovery(true)
+		})
+
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 201, len(actual))
+		for _, f := range actual {
+			require.NotNil(t, f.Discovered)
+			assert.False(t, f.Discovered.Before(before))
+		}
+	})
+
+	t.Run("ParseBytes starting mid-line", func(t *testing.T) {
+		data := []byte(`_field: domain            2 ,                5403068  bytes allocated
+d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing auxhist23_d01_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds
+SUCCESS COMPLETE WRF
+`)
+		actual, err := ParseBytes(data, 20*time.Millisecond).Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "auxhist23",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
+			Filename:       "auxhist23_d01_2021-08-06_00:00:00",
+			HourProgr:      48,
+			Elapsed:        101530 * time.Microsecond,
+			ElapsedSeconds: 0.10153,
+		}}, actual)
+	})
+
+	t.Run("skip first line", func(t *testing.T) {
+		parseFragment := func(skip bool) ([]wrfhours.FileInfo, error) {
+			parser := parseFixture(t, "fragment-first-line", func(parser *wrfhours.Parser) {
+				parser.SetSkipFirstLine(skip)
+			})
+			return parser.Collect()
+		}
+
+		_, err := parseFragment(false)
+		assert.EqualError(t, err, "Wrong format for start instant line `d01 2021-08-04`: line must contains at leas 3 space separated parts. e.g. `d01 2021-08-04_00:00:00 something`")
+
+		actual, err := parseFragment(true)
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(actual))
+		assert.Equal(t, "auxhist23_d01_2021-08-06_00:00:00", actual[0].Filename)
+	})
+
+	t.Run("HourList", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		hours, err := results.HourList("wrfout", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0}, hours)
+
+		results, err = ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		hours, err = results.HourList("wrfout", 3)
+		require.NoError(t, err)
+		expected := []int{}
+		for h := 0; h <= 48; h++ {
+			expected = append(expected, h)
+		}
+		assert.Equal(t, expected, hours)
+	})
+
+	t.Run("Stop terminates the parser goroutine", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		closed := make(chan struct{})
+		results.SetOnClose(func() error {
+			close(closed)
+			return nil
+		})
+
+		f := <-results.Files
+		require.NoError(t, f.Err)
+		results.Stop()
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("parser goroutine did not exit")
+		}
+	})
+
+	t.Run("Stop does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			f := <-results.Files
+			require.NoError(t, f.Err)
+		}
+		results.Stop()
+
+		// assert.Eventually can't be used here, since it
+		// evaluates the condition in a new goroutine.
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	})
+
+	t.Run("Stop after an unread timeout does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		r, w := io.Pipe()
+		defer w.Close()
+		results := Parse(r, 20*time.Millisecond)
+		fmt.Fprintln(w, "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated")
+		fmt.Fprintln(w, "Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds")
+		f := <-results.Files
+		require.NoError(t, f.Err)
+
+		// the timeout expires while nobody reads the files.
+		time.Sleep(100 * time.Millisecond)
+		results.Stop()
+		w.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	})
+
+	t.Run("Drain does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
 
 		results, err := ParseFile(fixtureFS, "rsl.out.0000")
 		require.NoError(t, err)
+		f := <-results.Files
+		require.NoError(t, f.Err)
+		results.Drain()
+
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	})
+
+	t.Run("parse dash separated times in filenames", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "dash-time")
+		require.NoError(t, err)
 		actual, err := results.Collect()
 		require.NoError(t, err)
 
-		checkResults(t, actual)
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_00-00-00",
+			HourProgr:      0,
+			Elapsed:        928150 * time.Microsecond,
+			ElapsedSeconds: 0.92815,
+		}, {
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_01-00-00",
+			HourProgr:      1,
+			Elapsed:        895550 * time.Microsecond,
+			ElapsedSeconds: 0.89555,
+		}}, actual)
+	})
+
+	t.Run("LatestPerStream", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		latest := wrfhours.LatestPerStream(actual)
+		assert.Equal(t, 9, len(latest))
+
+		assert.Equal(t, wrfhours.FileInfo{
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_00:00:00",
+			HourProgr:      0,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+		}, latest["wrfout/d01"])
 
+		assert.Equal(t, wrfhours.FileInfo{
+			Type:           "auxhist23",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
+			Filename:       "auxhist23_d03_2021-08-06_00:00:00",
+			HourProgr:      48,
+			Elapsed:        9905980 * time.Microsecond,
+			ElapsedSeconds: 9.90598,
+		}, latest["auxhist23/d03"])
 	})
 
-}
+	t.Run("WaitFor", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		defer results.Stop()
 
-type failingWriter struct{}
+		actual, err := results.WaitFor(context.Background(), wrfhours.Filter{
+			Type:     "wrfout",
+			Domain:   3,
+			HourFrom: 10,
+			HourTo:   11,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, wrfhours.FileInfo{
+			Type:           "wrfout",
+			Domain:         3,
+			Instant:        time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d03_2021-08-04_10:00:00",
+			HourProgr:      10,
+			Elapsed:        887110 * time.Microsecond,
+			ElapsedSeconds: 0.88711,
+		}, actual)
+	})
 
-func (w failingWriter) Write(p []byte) (n int, err error) {
-	return 0, fmt.Errorf("TEST")
-}
+	t.Run("WaitFor on missing file", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
 
-func checkResults(t *testing.T, actual []wrfhours.FileInfo) {
-	assert.Equal(t, 201, len(actual))
+		_, err = results.WaitFor(context.Background(), wrfhours.Filter{Type: "wrfout", Domain: 4})
+		assert.EqualError(t, err, "stream completed without files matching {Type:wrfout Domain:4 HourFrom:0 HourTo:0}")
+	})
 
-	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "wrfout",
-		Domain:    1,
-		Instant:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
-		Filename:  "wrfout_d01_2021-08-04_00:00:00",
-		HourProgr: 0,
-	}, actual[0])
+	t.Run("WaitFor with cancelled context", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		results := Parse(r, time.Second)
+		defer results.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := results.WaitFor(ctx, wrfhours.Filter{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
 
-	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "wrfout",
-		Domain:    3,
-		Instant:   time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
-		Filename:  "wrfout_d03_2021-08-04_01:00:00",
-		HourProgr: 1,
-	}, actual[10])
+	t.Run("CompletedSuccessfully", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		_, err = results.Collect()
+		require.NoError(t, err)
+		assert.True(t, results.CompletedSuccessfully())
 
-	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "auxhist23",
-		Domain:    3,
-		Instant:   time.Date(2021, 8, 5, 23, 0, 0, 0, time.UTC),
-		Filename:  "auxhist23_d03_2021-08-05_23:00:00",
-		HourProgr: 47,
-	}, actual[196])
+		results = Parse(strings.NewReader(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing auxhist23_d01_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds
+`), 20*time.Millisecond)
+		_, err = results.Collect()
+		assert.EqualError(t, err, "input stream completed without success log line")
+		assert.False(t, results.CompletedSuccessfully())
+	})
+
+	t.Run("skip restart files recognized by matcher", func(t *testing.T) {
+		parser := wrfhours.NewParser(20 * time.Millisecond)
+		parser.SetRestartMatcher(func(filename string) bool {
+			return filename == "restart" || strings.HasPrefix(filename, "wrfrst_")
+		})
+		go parser.Parse(strings.NewReader(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfrst_d01_2021-08-05_00:00:00 for domain        1:    1.33332 elapsed seconds
+Timing for Writing restart for domain        1:    1.33332 elapsed seconds
+Timing for Writing auxhist23_d01_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds
+SUCCESS COMPLETE WRF
+`))
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(actual))
+		assert.Equal(t, "auxhist23_d01_2021-08-06_00:00:00", actual[0].Filename)
+	})
+
+	t.Run("verify domain", func(t *testing.T) {
+		parseMismatch := func(verify bool) ([]wrfhours.FileInfo, error) {
+			parser := parseFixture(t, "wrong-domain-mismatch", func(parser *wrfhours.Parser) {
+				parser.SetVerifyDomain(verify)
+			})
+			return parser.Collect()
+		}
+
+		actual, err := parseMismatch(false)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(actual))
+
+		actual, err = parseMismatch(true)
+		assert.Nil(t, actual)
+		assert.EqualError(t, err, "Wrong format for timing line `Timing for Writing auxhist23_d03_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds`: filename domain 3 differs from trailing domain 1")
+	})
+
+	t.Run("strip ANSI escape sequences", func(t *testing.T) {
+		parser := parseFixture(t, "ansi-colors", func(parser *wrfhours.Parser) {
+			parser.SetStripANSI(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_01:00:00",
+			HourProgr:      1,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+		}}, actual)
+	})
+
+	t.Run("WriteOpenMetrics", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var out recordingWriter
+		require.NoError(t, wrfhours.WriteOpenMetrics(results.Files, &out))
+		require.Equal(t, 201, len(out.writes))
+
+		samples, err := parseOpenMetrics(out.writes[0])
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{
+			`wrf_file_written_total{type="wrfout",domain="1"}`: 1,
+		}, samples)
+
+		samples, err = parseOpenMetrics(out.writes[200])
+		require.NoError(t, err)
+		assert.Equal(t, map[string]float64{
+			`wrf_file_written_total{type="auxhist2",domain="1"}`:  1,
+			`wrf_file_written_total{type="auxhist2",domain="2"}`:  1,
+			`wrf_file_written_total{type="auxhist2",domain="3"}`:  49,
+			`wrf_file_written_total{type="auxhist23",domain="1"}`: 49,
+			`wrf_file_written_total{type="auxhist23",domain="2"}`: 1,
+			`wrf_file_written_total{type="auxhist23",domain="3"}`: 49,
+			`wrf_file_written_total{type="wrfout",domain="1"}`:    1,
+			`wrf_file_written_total{type="wrfout",domain="2"}`:    1,
+			`wrf_file_written_total{type="wrfout",domain="3"}`:    49,
+		}, samples)
+	})
+
+	t.Run("WriteOpenMetrics sorts domains numerically", func(t *testing.T) {
+		files := make(chan wrfhours.FileInfo, 2)
+		files <- wrfhours.FileInfo{Type: "wrfout", Domain: 10}
+		files <- wrfhours.FileInfo{Type: "wrfout", Domain: 2}
+		close(files)
+
+		var out recordingWriter
+		require.NoError(t, wrfhours.WriteOpenMetrics(files, &out))
+		require.Equal(t, 2, len(out.writes))
+		assert.Equal(t, "# TYPE wrf_file_written counter\n"+
+			"wrf_file_written_total{type=\"wrfout\",domain=\"2\"} 1\n"+
+			"wrf_file_written_total{type=\"wrfout\",domain=\"10\"} 1\n"+
+			"# EOF\n", out.writes[1])
+	})
+
+	t.Run("parseOpenMetrics rejects repeated labelsets", func(t *testing.T) {
+		_, err := parseOpenMetrics("# TYPE wrf_file_written counter\n" +
+			"wrf_file_written_total{type=\"wrfout\",domain=\"1\"} 1\n" +
+			"wrf_file_written_total{type=\"wrfout\",domain=\"1\"} 2\n" +
+			"# EOF\n")
+		assert.EqualError(t, err, `line 3: repeated sample wrf_file_written_total{type="wrfout",domain="1"}`)
+	})
+
+	t.Run("WriteOpenMetrics on failing writer", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		defer results.Stop()
+
+		err = wrfhours.WriteOpenMetrics(results.Files, failingWriter{})
+		assert.EqualError(t, err, "WriteOpenMetrics failed: error while writing: TEST")
+	})
+
+	t.Run("custom classifier", func(t *testing.T) {
+		parser := wrfhours.NewParser(20 * time.Millisecond)
+		parser.SetClassifier(forkClassifier{})
+		go parser.Parse(strings.NewReader(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing of Write wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds
+Timing for Writing wrfout_d01_2021-08-04_02:00:00 for domain        1:    0.47585 elapsed seconds
+d01 2021-08-04_02:00:00 wrf: SUCCESS COMPLETE WRF
+`))
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_01:00:00",
+			HourProgr:      1,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+		}}, actual)
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		var events []wrfhours.Event
+		for event := range results.Events() {
+			events = append(events, event)
+		}
+
+		require.Equal(t, 203, len(events))
+		assert.Equal(t, wrfhours.Event{
+			Kind:  wrfhours.StartEvent,
+			Start: time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		}, events[0])
+
+		var files []wrfhours.FileInfo
+		for _, event := range events[1:202] {
+			require.Equal(t, wrfhours.FileEvent, event.Kind)
+			files = append(files, event.File)
+		}
+		checkResults(t, files)
+
+		assert.Equal(t, wrfhours.Event{Kind: wrfhours.SuccessEvent}, events[202])
+	})
+
+	t.Run("Events on error", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "wrong-instant")
+		require.NoError(t, err)
+
+		var events []wrfhours.Event
+		for event := range results.Events() {
+			events = append(events, event)
+		}
+
+		require.Equal(t, 2, len(events))
+		assert.Equal(t, wrfhours.Event{
+			Kind:  wrfhours.StartEvent,
+			Start: time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		}, events[0])
+		assert.Equal(t, wrfhours.ErrorEvent, events[1].Kind)
+		assert.Error(t, events[1].Err)
+	})
+
+	t.Run("Events on abort after start line", func(t *testing.T) {
+		results := wrfhours.NewParser(100 * time.Millisecond)
+		results.SetDetectAbort(true)
+		go results.Parse(strings.NewReader(
+			"d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n" +
+				"-------------- FATAL CALLED ---------------\n",
+		))
+
+		var events []wrfhours.Event
+		for event := range results.Events() {
+			events = append(events, event)
+		}
+
+		require.Equal(t, 2, len(events))
+		assert.Equal(t, wrfhours.Event{
+			Kind:  wrfhours.StartEvent,
+			Start: time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		}, events[0])
+		assert.Equal(t, wrfhours.ErrorEvent, events[1].Kind)
+		var abortErr *wrfhours.AbortError
+		assert.True(t, errors.As(events[1].Err, &abortErr))
+	})
+
+	t.Run("Summarize does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		// the log stalls after the first file.
+		r, w := io.Pipe()
+		go fmt.Fprint(w, `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+`)
+		_, err := wrfhours.Summarize(r, 20*time.Millisecond)
+		assert.Error(t, err)
+
+		// and resumes after the timeout.
+		fmt.Fprintln(w, "Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds")
+		w.Close()
+
+		assertNoLeak(t, before)
+	})
+
+	t.Run("Summarize", func(t *testing.T) {
+		file, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer file.Close()
+
+		summary, err := wrfhours.Summarize(file, 100*time.Millisecond)
+		require.NoError(t, err)
+
+		buff, err := json.Marshal(summary)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"Start": "2021-08-04T00:00:00Z",
+			"End": "2021-08-06T00:00:00Z",
+			"Domains": [1, 2, 3],
+			"Types": ["auxhist2", "auxhist23", "wrfout"],
+			"Files": 201,
+			"FilesByType": {"auxhist2": 51, "auxhist23": 99, "wrfout": 51},
+			"Completed": true
+		}`, string(buff))
+	})
+
+	t.Run("Summarize incomplete run", func(t *testing.T) {
+		summary, err := wrfhours.Summarize(strings.NewReader(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing auxhist23_d01_2021-08-06_00:00:00 for domain        1:    0.10153 elapsed seconds
+`), 20*time.Millisecond)
+		assert.EqualError(t, err, "input stream completed without success log line")
+		assert.Equal(t, 1, summary.Files)
+		assert.False(t, summary.Completed)
+	})
+
+	t.Run("stop after max files", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetMaxFiles(10)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, 10, len(actual))
+		assert.Equal(t, "auxhist23_d01_2021-08-04_01:00:00", actual[9].Filename)
+		assert.False(t, parser.CompletedSuccessfully())
+	})
+
+	t.Run("ParseFileWithStart", func(t *testing.T) {
+		results, start, err := ParseFileWithStart(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+		actual, err := results.Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("ParseFileWithStart without start line", func(t *testing.T) {
+		results, _, err := ParseFileWithStart(fixtureFS, "wrong-without-start-instant")
+		assert.Nil(t, results)
+		assert.EqualError(t, err, "Start line not found yet: timing line 1 `Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds` precedes it")
+	})
+
+	t.Run("ParseFileWithStart with malformed timing line", func(t *testing.T) {
+		results, start, err := ParseFileWithStart(fixtureFS, "wrong-instant")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+		_, err = results.Collect()
+		assert.Error(t, err)
+	})
+
+	t.Run("ParseFileWithStart compressed", func(t *testing.T) {
+		results, start, err := ParseFileWithStart(fixtureFS, "rsl.out.0000.zst")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+		actual, err := results.Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("parse indented start line", func(t *testing.T) {
+		results, start, err := ParseFileWithStart(fixtureFS, "indented-start")
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+		actual, err := results.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(actual))
+		assert.Equal(t, 1, actual[0].HourProgr)
+	})
+
+	t.Run("parse start line of a different domain", func(t *testing.T) {
+		parser := parseFixture(t, "d02-start", func(parser *wrfhours.Parser) {
+			parser.SetStartDomainPrefix("d02 ")
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "wrfout",
+			Domain:         2,
+			Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d02_2021-08-04_01:00:00",
+			HourProgr:      1,
+			Elapsed:        11968440 * time.Microsecond,
+			ElapsedSeconds: 11.96844,
+		}}, actual)
+	})
+
+	t.Run("FilterFiles", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 201, len(actual))
+
+		filtered := wrfhours.FilterFiles(actual, wrfhours.Filter{Type: "wrfout", Domain: 3})
+		assert.Equal(t, 49, len(filtered))
+		for i, file := range filtered {
+			assert.Equal(t, "wrfout", file.Type)
+			assert.Equal(t, 3, file.Domain)
+			assert.Equal(t, i, file.HourProgr)
+		}
+	})
+
+	t.Run("NewParserNoTimeout", func(t *testing.T) {
+		file, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer file.Close()
+
+		before := runtime.NumGoroutine()
+		parser := wrfhours.NewParserNoTimeout()
+		assertNoLeak(t, before)
+
+		go parser.Parse(file)
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("parse multiple runs", func(t *testing.T) {
+		parseRuns := func(multiRun bool) ([]wrfhours.FileInfo, error) {
+			parser := parseFixture(t, "multi-run", func(parser *wrfhours.Parser) {
+				parser.SetMultiRun(multiRun)
+			})
+			return parser.Collect()
+		}
+
+		actual, err := parseRuns(false)
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(actual))
+
+		actual, err = parseRuns(true)
+		require.NoError(t, err)
+		assert.Equal(t, []wrfhours.FileInfo{{
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_00:00:00",
+			HourProgr:      0,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+		}, {
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-04_01:00:00",
+			HourProgr:      1,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+		}, {
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 5, 0, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-05_00:00:00",
+			HourProgr:      0,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+			RunIndex:       1,
+		}, {
+			Type:           "wrfout",
+			Domain:         1,
+			Instant:        time.Date(2021, 8, 5, 1, 0, 0, 0, time.UTC),
+			Filename:       "wrfout_d01_2021-08-05_01:00:00",
+			HourProgr:      1,
+			Elapsed:        475850 * time.Microsecond,
+			ElapsedSeconds: 0.47585,
+			RunIndex:       1,
+		}}, actual)
+	})
+
+	t.Run("parse multiple runs with last one incomplete", func(t *testing.T) {
+		parser := wrfhours.NewParser(20 * time.Millisecond)
+		parser.SetMultiRun(true)
+		go parser.Parse(strings.NewReader(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF
+d01 2021-08-05_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-05_00:00:00 for domain        1:    0.47585 elapsed seconds
+`))
+		_, err := parser.Collect()
+		assert.EqualError(t, err, "input stream completed without success log line")
+	})
+
+	t.Run("keep source line", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetKeepSourceLine(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 201, len(actual))
+
+		assert.Equal(t, "Timing for Writing wrfout_d03_2021-08-04_01:00:00 for domain        3:    0.89555 elapsed seconds", actual[10].SourceLine)
+		for _, f := range actual {
+			assert.True(t, strings.HasPrefix(f.SourceLine, "Timing for Writing "+f.Filename+" "))
+		}
+	})
+
+	t.Run("ParseRotated", func(t *testing.T) {
+		results, err := ParseRotated(fixtureFS, []string{"rotated.0", "rotated.1"}, 20*time.Millisecond)
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_00:00:00", actual[0].Filename)
+		assert.Equal(t, "wrfout_d01_2021-08-04_01:00:00", actual[1].Filename)
+		assert.Equal(t, "wrfout_d01_2021-08-04_02:00:00", actual[2].Filename)
+		assert.Equal(t, 2, actual[2].HourProgr)
+	})
+
+	t.Run("ParseRotated with missing file", func(t *testing.T) {
+		results, err := ParseRotated(fixtureFS, []string{"rotated.0", "doesnt-exist"}, 20*time.Millisecond)
+		assert.Nil(t, results)
+		assert.EqualError(t, err, "open doesnt-exist: file does not exist")
+	})
+
+	t.Run("emit done sentinel", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetEmitDone(true)
+		})
+
+		var actual []wrfhours.FileInfo
+		for f := range parser.Files {
+			require.NoError(t, f.Err)
+			actual = append(actual, f)
+		}
+
+		require.Equal(t, 202, len(actual))
+		for _, f := range actual[:201] {
+			assert.False(t, f.Done)
+		}
+		assert.Equal(t, wrfhours.FileInfo{Done: true}, actual[201])
+	})
+
+	t.Run("no done sentinel on error", func(t *testing.T) {
+		parser := parseFixture(t, "wrong-instant", func(parser *wrfhours.Parser) {
+			parser.SetEmitDone(true)
+		})
+
+		var actual []wrfhours.FileInfo
+		for f := range parser.Files {
+			actual = append(actual, f)
+		}
+		require.Equal(t, 1, len(actual))
+		assert.Error(t, actual[0].Err)
+	})
+
+	t.Run("Timeline", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		timeline := wrfhours.Timeline(actual)
+		require.Equal(t, 201, len(timeline))
+		for i, entry := range timeline {
+			assert.Equal(t, i+1, entry.CumulativeCount)
+			if i > 0 {
+				assert.False(t, entry.Instant.Before(timeline[i-1].Instant))
+			}
+		}
+		assert.Equal(t, wrfhours.TimelineEntry{
+			Instant:         time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
+			Type:            "auxhist23",
+			Domain:          1,
+			HourProgr:       48,
+			CumulativeCount: 201,
+		}, timeline[200])
+	})
+
+	t.Run("parse elapsed seconds with decimal commas", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "comma-decimals")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, 475850*time.Microsecond, actual[0].Elapsed)
+		assert.Equal(t, 11968440*time.Microsecond, actual[1].Elapsed)
+	})
+
+	t.Run("parse timing lines without elapsed seconds", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "missing-elapsed")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 4, len(actual))
+		for _, f := range actual[:3] {
+			assert.Equal(t, time.Duration(0), f.Elapsed, f.Filename)
+			assert.Equal(t, 0.0, f.ElapsedSeconds, f.Filename)
+		}
+		assert.Equal(t, 2, actual[2].HourProgr)
+		assert.Equal(t, 475850*time.Microsecond, actual[3].Elapsed)
+	})
+
+	t.Run("parse custom file prefix", func(t *testing.T) {
+		parser := parseFixture(t, "custom-prefix", func(parser *wrfhours.Parser) {
+			parser.SetFilePrefix("Timing for Writing of ")
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, "wrfout", actual[0].Type)
+		assert.Equal(t, "wrfout_d01_2021-08-04_00:00:00", actual[0].Filename)
+		assert.Equal(t, "wrfout_d01_2021-08-04_01:00:00", actual[1].Filename)
+	})
+
+	t.Run("emit step timing", func(t *testing.T) {
+		parser := parseFixture(t, "step-timing", func(parser *wrfhours.Parser) {
+			parser.SetEmitStepTiming(true)
+		})
+
+		var steps []wrfhours.StepTiming
+		stepsRead := make(chan struct{})
+		go func() {
+			defer close(stepsRead)
+			for step := range parser.StepTimings() {
+				steps = append(steps, step)
+			}
+		}()
+
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(actual))
+		<-stepsRead
+
+		assert.Equal(t, []wrfhours.StepTiming{{
+			Instant: time.Date(2021, 8, 4, 0, 0, 4, 0, time.UTC),
+			Domain:  3,
+			Elapsed: 1605540 * time.Microsecond,
+		}, {
+			Instant: time.Date(2021, 8, 4, 0, 0, 12, 0, time.UTC),
+			Domain:  2,
+			Elapsed: 147960 * time.Microsecond,
+		}, {
+			Instant: time.Date(2021, 8, 4, 0, 0, 36, 0, time.UTC),
+			Domain:  1,
+			Elapsed: 154720 * time.Microsecond,
+		}}, steps)
+	})
+
+	t.Run("CollectPartial on timeout", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		go fmt.Fprint(w, `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds
+Timing for Writing wrfout_d01_2021-08-04_02:00:00 for domain        1:    0.47585 elapsed seconds
+`)
+
+		actual, err := Parse(r, 30*time.Millisecond).CollectPartial()
+		assert.EqualError(t, err, "Timeout expired: no new files created for more than 30ms")
+		require.Equal(t, 3, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_02:00:00", actual[2].Filename)
+	})
+
+	t.Run("dedup repeated timing lines", func(t *testing.T) {
+		parse := func(dedup bool) []string {
+			parser := parseFixture(t, "duplicated-lines", func(parser *wrfhours.Parser) {
+				parser.SetDedup(dedup)
+			})
+			actual, err := parser.Collect()
+			require.NoError(t, err)
+
+			filenames := []string{}
+			for _, f := range actual {
+				filenames = append(filenames, f.Filename)
+			}
+			return filenames
+		}
+
+		assert.Equal(t, 5, len(parse(false)))
+		assert.Equal(t, []string{
+			"wrfout_d01_2021-08-04_00:00:00",
+			"wrfout_d03_2021-08-04_00:00:00",
+			"wrfout_d01_2021-08-04_01:00:00",
+		}, parse(true))
+	})
+
+	t.Run("ParseMerged", func(t *testing.T) {
+		out := strings.NewReader(`starting wrf task            0  of            1
+d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+`)
+		timings := strings.NewReader(`Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds
+d01 2021-08-04_01:00:00 wrf: SUCCESS COMPLETE WRF
+`)
+
+		actual, err := ParseMerged(20*time.Millisecond, timings, out).Collect()
+		require.NoError(t, err)
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_00:00:00", actual[0].Filename)
+		assert.Equal(t, 1, actual[1].HourProgr)
+	})
+
+	t.Run("ParseMerged does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		out := strings.NewReader(`starting wrf task            0  of            1
+d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+d01 2021-08-04_01:00:00 wrf: SUCCESS COMPLETE WRF
+taskid: 0 hostname: r500c01n02
+taskid: 0 hostname: r500c01n02
+`)
+		errs := strings.NewReader(`taskid: 0 hostname: r500c01n02
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+taskid: 0 hostname: r500c01n02
+taskid: 0 hostname: r500c01n02
+`)
+
+		_, err := ParseMerged(20*time.Millisecond, out, errs).Collect()
+		require.NoError(t, err)
+
+		assertNoLeak(t, before)
+	})
+
+	t.Run("KnownType", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		types := map[string]bool{}
+		for _, file := range actual {
+			assert.True(t, wrfhours.KnownType(file.Type), file.Type)
+			types[file.Type] = true
+		}
+		assert.Equal(t, map[string]bool{
+			wrfhours.TypeWrfout:         true,
+			wrfhours.TypeAuxhist + "2":  true,
+			wrfhours.TypeAuxhist + "23": true,
+		}, types)
+
+		assert.False(t, wrfhours.KnownType("wrfotu"))
+		assert.False(t, wrfhours.KnownType(""))
+	})
+
+	t.Run("LastN", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		all, err := results.Collect()
+		require.NoError(t, err)
+
+		results, err = ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.LastN(5)
+		require.NoError(t, err)
+		assert.Equal(t, all[196:], actual)
+
+		results, err = ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err = results.LastN(300)
+		require.NoError(t, err)
+		assert.Equal(t, all, actual)
+	})
+
+	t.Run("parse fractional seconds in filenames", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "fractional-seconds")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 500*int(time.Millisecond), time.UTC), actual[0].Instant)
+		assert.Equal(t, 0, actual[0].HourProgr)
+		assert.Equal(t, time.Date(2021, 8, 4, 1, 0, 0, 250*int(time.Millisecond), time.UTC), actual[1].Instant)
+		assert.Equal(t, 1, actual[1].HourProgr)
+	})
+
+	t.Run("SetHourRounding", func(t *testing.T) {
+		hours := func(rounding wrfhours.HourRounding) []int {
+			parser := parseFixture(t, "sub-hourly", func(parser *wrfhours.Parser) {
+				parser.SetHourRounding(rounding)
+			})
+			actual, err := parser.Collect()
+			require.NoError(t, err)
+
+			hours := []int{}
+			for _, f := range actual {
+				hours = append(hours, f.HourProgr)
+			}
+			return hours
+		}
+
+		assert.Equal(t, []int{0, 0, 0}, hours(wrfhours.HourTruncate))
+		assert.Equal(t, []int{0, 0, 1}, hours(wrfhours.HourRound))
+		assert.Equal(t, []int{0, 1, 1}, hours(wrfhours.HourCeil))
+	})
+
+	t.Run("parse elapsed seconds", func(t *testing.T) {
+		actual, err := ParseBytes([]byte(`d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing auxhist23_d03_2021-08-04_01:00:00 for domain        3:   10.02259 elapsed seconds
+d01 2021-08-04_01:00:00 wrf: SUCCESS COMPLETE WRF
+`), 20*time.Millisecond).Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 1, len(actual))
+		assert.Equal(t, 10.02259, actual[0].ElapsedSeconds)
+		assert.Equal(t, 10022590*time.Microsecond, actual[0].Elapsed)
+		assert.Equal(t, actual[0].ElapsedSeconds, actual[0].Elapsed.Seconds())
+	})
+
+	t.Run("CheckLog", func(t *testing.T) {
+		check := func(data []byte) (bool, string) {
+			return wrfhours.CheckLog(bytes.NewReader(data), 100*time.Millisecond)
+		}
+		fixture := func(name string) []byte {
+			data, err := fs.ReadFile(fixtureFS, name)
+			require.NoError(t, err)
+			return data
+		}
+
+		complete := fixture("rsl.out.0000")
+		ok, detail := check(complete)
+		assert.True(t, ok)
+		assert.Equal(t, "completed successfully, 201 files written", detail)
+
+		ok, detail = check(complete[:len(complete)/2])
+		assert.False(t, ok)
+		assert.Equal(t, "incomplete: input stream completed without success log line", detail)
+
+		ok, detail = check(fixture("wrong-instant"))
+		assert.False(t, ok)
+		assert.True(t, strings.HasPrefix(detail, "format error: Wrong format for timing line"), detail)
+
+		ok, detail = check(fixture("aborted"))
+		assert.False(t, ok)
+		assert.Equal(t, "aborted: WRF aborted: `-------------- FATAL CALLED ---------------`", detail)
+
+		// the log stalls after the first file.
+		aborted := fixture("aborted")
+		r, w := io.Pipe()
+		defer w.Close()
+		go w.Write(aborted[:bytes.Index(aborted, []byte("---"))])
+		ok, detail = wrfhours.CheckLog(r, 20*time.Millisecond)
+		assert.False(t, ok)
+		assert.Equal(t, "timeout: Timeout expired: no new files created for more than 20ms", detail)
+	})
+
+	t.Run("CheckLog does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		// the log stalls after the first file.
+		r, w := io.Pipe()
+		go fmt.Fprint(w, `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+`)
+		ok, _ := wrfhours.CheckLog(r, 20*time.Millisecond)
+		assert.False(t, ok)
+
+		// and resumes after the timeout.
+		fmt.Fprintln(w, "Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds")
+		w.Close()
+
+		assertNoLeak(t, before)
+	})
+
+	t.Run("SetTee", func(t *testing.T) {
+		var tee bytes.Buffer
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetTee(&tee)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSuffix(tee.String(), "\n"), "\n")
+		require.Equal(t, len(actual), len(lines))
+		for i, line := range lines {
+			var written wrfhours.FileInfo
+			require.NoError(t, json.Unmarshal([]byte(line), &written))
+			assert.Equal(t, actual[i], written)
+		}
+	})
+
+	t.Run("SetTee on failing writer", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetTee(failingWriter{})
+		})
+		_, err := parser.Collect()
+		assert.EqualError(t, err, "Tee failed: error while writing: TEST")
+	})
+
+	t.Run("emit read errors", func(t *testing.T) {
+		r, w := io.Pipe()
+		w.CloseWithError(errors.New("TEST"))
+		_, err := Parse(r, 20*time.Millisecond).Collect()
+		assert.EqualError(t, err, "TEST")
+	})
+
+	t.Run("timeout with a fake clock", func(t *testing.T) {
+		clock := newFakeClock()
+		parser := wrfhours.NewParserWithClock(time.Minute, clock)
+		r, w := io.Pipe()
+		defer w.Close()
+		go parser.Parse(r)
+		go fmt.Fprint(w, `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+`)
+
+		f := <-parser.Files
+		require.NoError(t, f.Err)
+
+		for after := range clock.afters {
+			if after.d == time.Minute {
+				after.c <- clock.Now().Add(after.d)
+				break
+			}
+		}
+
+		f = <-parser.Files
+		assert.EqualError(t, f.Err, "Timeout expired: no new files created for more than 1m0s")
+	})
+
+	t.Run("heartbeat with a fake clock", func(t *testing.T) {
+		clock := newFakeClock()
+		parser := wrfhours.NewParserWithClock(time.Hour, clock)
+		defer parser.Stop()
+		beats := make(chan struct{}, 10)
+		parser.SetHeartbeat(time.Minute, func() { beats <- struct{}{} })
+		r, w := io.Pipe()
+		defer w.Close()
+		go parser.Parse(r)
+
+		nextBeat := func() fakeTimer {
+			for {
+				if after := <-clock.afters; after.d == time.Minute {
+					return after
+				}
+			}
+		}
+
+		// a minute hasn't passed yet since the parse started.
+		after := nextBeat()
+		after.c <- clock.Now().Add(after.d)
+		after = nextBeat()
+		assert.Equal(t, 0, len(beats))
+
+		clock.advance(time.Minute)
+		after.c <- clock.Now()
+		<-beats
+	})
+
+	t.Run("SetReadRetry", func(t *testing.T) {
+		parse := func(retries int) ([]wrfhours.FileInfo, error) {
+			data, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+			require.NoError(t, err)
+
+			parser := wrfhours.NewParser(100 * time.Millisecond)
+			parser.SetReadRetry(retries, time.Millisecond)
+			go parser.Parse(&flakyReader{data: data, failures: 2})
+			return parser.Collect()
+		}
+
+		actual, err := parse(2)
+		require.NoError(t, err)
+		checkResults(t, actual)
+
+		_, err = parse(1)
+		assert.EqualError(t, err, "temporary failure")
+
+		_, err = parse(0)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("DiffRuns", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		modified := append([]wrfhours.FileInfo{}, actual[:10]...)
+		modified = append(modified, actual[11:]...)
+		extra := wrfhours.FileInfo{Type: "wrfout", Domain: 3, Filename: "wrfout_d03_2021-08-06_01:00:00"}
+		modified = append(modified, extra)
+
+		onlyA, onlyB := wrfhours.DiffRuns(actual, modified)
+		assert.Equal(t, []wrfhours.FileInfo{actual[10]}, onlyA)
+		assert.Equal(t, []wrfhours.FileInfo{extra}, onlyB)
+
+		onlyA, onlyB = wrfhours.DiffRuns(actual, actual)
+		assert.Empty(t, onlyA)
+		assert.Empty(t, onlyB)
+	})
+
+	t.Run("ParseFrom", func(t *testing.T) {
+		data, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		all, err := ParseBytes(data, 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+
+		offset := int64(len(data) / 2)
+		actual, err := ParseFrom(bytes.NewReader(data), int64(len(data)), offset, 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+
+		require.True(t, len(actual) > 0 && len(actual) < len(all), len(actual))
+		assert.Equal(t, all[len(all)-len(actual):], actual)
+		skipped := all[len(all)-len(actual)-1]
+		assert.True(t, bytes.Contains(data[:offset], []byte(skipped.Filename)))
+		assert.False(t, bytes.Contains(data[:offset], []byte(actual[0].Filename)))
+
+		actual, err = ParseFrom(bytes.NewReader(data), int64(len(data)), 0, 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("ParseFrom an offset in the start line", func(t *testing.T) {
+		data, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		offset := int64(bytes.Index(data, []byte("d01 2021-08-04_00:00:00")) + 10)
+		actual, err := ParseFrom(bytes.NewReader(data), int64(len(data)), offset, 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("ParseFrom with SetStartDomainPrefix", func(t *testing.T) {
+		data, err := fs.ReadFile(fixtureFS, "d02-start")
+		require.NoError(t, err)
+		offset := int64(bytes.Index(data, []byte("Timing")))
+
+		parser := wrfhours.NewParser(100 * time.Millisecond)
+		parser.SetStartDomainPrefix("d02 ")
+		go parser.ParseFrom(bytes.NewReader(data), int64(len(data)), offset)
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(actual))
+		assert.Equal(t, 1, actual[0].HourProgr)
+	})
+
+	t.Run("FilesInHours", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.FilesInHours(0, 10)
+		require.NoError(t, err)
+
+		// 10 hours of the 4 hourly streams, plus
+		// the 5 streams with a single file at hour 0.
+		assert.Equal(t, 45, len(actual))
+		for _, file := range actual {
+			assert.True(t, file.HourProgr >= 0 && file.HourProgr < 10, file.HourProgr)
+		}
+	})
+
+	t.Run("SetStart", func(t *testing.T) {
+		start := time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)
+		parse := func(override bool) ([]int, time.Time) {
+			parser := parseFixture(t, "preset-start", func(parser *wrfhours.Parser) {
+				parser.SetStart(start)
+				parser.SetStartOverride(override)
+			})
+			actual, err := parser.Collect()
+			require.NoError(t, err)
+
+			hours := []int{}
+			for _, f := range actual {
+				hours = append(hours, f.HourProgr)
+			}
+			return hours, *parser.Start
+		}
+
+		hours, actualStart := parse(false)
+		assert.Equal(t, []int{1, 2}, hours)
+		assert.Equal(t, start, actualStart)
+
+		hours, actualStart = parse(true)
+		assert.Equal(t, []int{1, 1}, hours)
+		assert.Equal(t, start.Add(time.Hour), actualStart)
+	})
+
+	t.Run("buffer timing lines preceding the start line", func(t *testing.T) {
+		parse := func(buffer bool) ([]wrfhours.FileInfo, error) {
+			parser := parseFixture(t, "early-timing-line", func(parser *wrfhours.Parser) {
+				parser.SetBufferEarlyFiles(buffer)
+			})
+			return parser.Collect()
+		}
+
+		_, err := parse(false)
+		assert.EqualError(t, err, "Start line not found yet: timing line 2 `Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds` precedes it")
+		assert.ErrorIs(t, err, wrfhours.ErrStartNotFound)
+
+		actual, err := parse(true)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_00:00:00", actual[0].Filename)
+		assert.Equal(t, 0, actual[0].HourProgr)
+		assert.Equal(t, 1, actual[1].HourProgr)
+	})
+
+	t.Run("buffer timing lines preceding the start line in metadata only mode", func(t *testing.T) {
+		parser := parseFixture(t, "early-timing-line", func(parser *wrfhours.Parser) {
+			parser.SetBufferEarlyFiles(true)
+			parser.SetMetadataOnly(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+
+		assert.Equal(t, wrfhours.RunMetadata{
+			Start:       time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			LastInstant: time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+			Completed:   true,
+		}, parser.Metadata())
+	})
+
+	t.Run("SetPathMapper", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetPathMapper(func(f wrfhours.FileInfo) string {
+				return filepath.Join(f.Type, f.Filename)
+			})
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		assert.Equal(t, filepath.Join("wrfout", "wrfout_d01_2021-08-04_00:00:00"), actual[0].RelPath())
+		assert.Equal(t, filepath.Join("auxhist23", "auxhist23_d03_2021-08-05_23:00:00"), actual[196].RelPath())
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err = results.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, "wrfout_d01_2021-08-04_00:00:00", actual[0].RelPath())
+	})
+
+	t.Run("Scanner", func(t *testing.T) {
+		file, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer file.Close()
+
+		actual := []wrfhours.FileInfo{}
+		s := wrfhours.NewScanner(file)
+		for s.Scan() {
+			actual = append(actual, s.File())
+		}
+		require.NoError(t, s.Err())
+		checkResults(t, actual)
+
+		file, err = fixtureFS.Open("wrong-instant")
+		require.NoError(t, err)
+		defer file.Close()
+		s = wrfhours.NewScanner(file)
+		for s.Scan() {
+		}
+		assert.Error(t, s.Err())
+		assert.False(t, s.Scan())
+	})
+
+	t.Run("ParseConfig", func(t *testing.T) {
+		file, err := fixtureFS.Open("header")
+		require.NoError(t, err)
+		defer file.Close()
+
+		config, err := wrfhours.ParseConfig(file)
+		require.NoError(t, err)
+		assert.Equal(t, &wrfhours.RunConfig{
+			Version: "V3.9.1.1",
+			Start:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			Domains: 3,
+			TimeSteps: map[int]time.Duration{
+				1: 36 * time.Second,
+				2: 12 * time.Second,
+				3: 4 * time.Second,
+			},
+		}, config)
+
+		_, err = wrfhours.ParseConfig(strings.NewReader("WRF V3.9.1.1 MODEL\n"))
+		assert.EqualError(t, err, "start line not found")
+	})
+
+	t.Run("FirstFileLatency", func(t *testing.T) {
+		results, start, err := ParseFileWithStart(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		latency := wrfhours.FirstFileLatency(start, actual)
+		assert.Equal(t, 3, len(latency))
+		assert.Equal(t, time.Duration(0), latency[3])
+
+		latency = wrfhours.FirstFileLatency(start.Add(-time.Hour), actual[10:])
+		assert.Equal(t, 2*time.Hour, latency[3])
+	})
+
+	t.Run("SetSuccessLine", func(t *testing.T) {
+		parser := parseFixture(t, "job-done", func(parser *wrfhours.Parser) {
+			parser.SetSuccessLine("JOB DONE")
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 2, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_01:00:00", actual[1].Filename)
+	})
+
+	t.Run("SetSuccessSuffix", func(t *testing.T) {
+		parser := parseFixture(t, "job-done", func(parser *wrfhours.Parser) {
+			parser.SetSuccessSuffix("SUCCESS COMPLETE WRF", "JOB DONE")
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		// `NOT JOB DONE` ends with the suffix too.
+		require.Equal(t, 2, len(actual))
+	})
+
+	t.Run("CheckMatrix", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		missing := wrfhours.CheckMatrix(actual, map[string][]int{
+			"wrfout":    {1, 2, 3},
+			"auxhist23": {3},
+		})
+		assert.Empty(t, missing)
+
+		results, err = ParseFile(fixtureFS, "missing-stream")
+		require.NoError(t, err)
+		actual, err = results.Collect()
+		require.NoError(t, err)
+
+		missing = wrfhours.CheckMatrix(actual, map[string][]int{
+			"wrfout":    {1, 2},
+			"auxhist23": {1, 2},
+		})
+		assert.Equal(t, []string{"no auxhist23 files written for domain 2"}, missing)
+	})
+
+	t.Run("SetDetectAbort", func(t *testing.T) {
+		parse := func(detect bool) ([]wrfhours.FileInfo, error) {
+			parser := parseFixture(t, "aborted", func(parser *wrfhours.Parser) {
+				parser.SetDetectAbort(detect)
+			})
+			return parser.Collect()
+		}
+
+		actual, err := parse(false)
+		assert.Nil(t, actual)
+		assert.ErrorIs(t, err, wrfhours.ErrIncomplete)
+
+		_, err = parse(true)
+		var abortErr *wrfhours.AbortError
+		require.ErrorAs(t, err, &abortErr)
+		assert.Equal(t, "-------------- FATAL CALLED ---------------", abortErr.Line)
+	})
+
+	t.Run("ParseStdStreams", func(t *testing.T) {
+		stdout := strings.NewReader(
+			"d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n" +
+				"Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds\n",
+		)
+		stderr := strings.NewReader(
+			"Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds\n" +
+				"-------------- FATAL CALLED ---------------\n",
+		)
+
+		_, err := ParseStdStreams(stdout, stderr, 100*time.Millisecond).Collect()
+		var abortErr *wrfhours.AbortError
+		require.ErrorAs(t, err, &abortErr)
+		assert.Equal(t, "-------------- FATAL CALLED ---------------", abortErr.Line)
+	})
+
+	t.Run("ParseStdStreams does not leak goroutines", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+
+		trailer := strings.Repeat("taskid: 0 hostname: r500c01n02\n", 3)
+		stdout := strings.NewReader(
+			"d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n" +
+				"d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF\n" + trailer,
+		)
+		_, err := ParseStdStreams(stdout, strings.NewReader(""), 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+
+		stdout = strings.NewReader(
+			"d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated\n" +
+				strings.Repeat(trailer, 100),
+		)
+		stderr := strings.NewReader("-------------- FATAL CALLED ---------------\n" +
+			"-------------- FATAL CALLED ---------------\n")
+		_, err = ParseStdStreams(stdout, stderr, 100*time.Millisecond).Collect()
+		var abortErr *wrfhours.AbortError
+		require.ErrorAs(t, err, &abortErr)
+
+		assertNoLeak(t, before)
+	})
+
+	t.Run("ParseStdStreams without abort", func(t *testing.T) {
+		stdout, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer stdout.Close()
+
+		actual, err := ParseStdStreams(stdout, strings.NewReader("some warning\n"), 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("SetMeta", func(t *testing.T) {
+		meta := map[string]string{"run": "42", "node": "wrf-1"}
+		var tee bytes.Buffer
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetMeta(meta)
+			parser.SetTee(&tee)
+		})
+		meta["run"] = "43"
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 201, len(actual))
+		for _, f := range actual {
+			assert.Equal(t, map[string]string{"run": "42", "node": "wrf-1"}, f.Meta)
+		}
+
+		var written wrfhours.FileInfo
+		line := strings.SplitN(tee.String(), "\n", 2)[0]
+		require.NoError(t, json.Unmarshal([]byte(line), &written))
+		assert.Equal(t, actual[0].Meta, written.Meta)
+	})
+
+	t.Run("ParseLines", func(t *testing.T) {
+		content, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			for _, line := range strings.Split(string(content), "\n") {
+				lines <- line
+			}
+		}()
+
+		actual, err := ParseLines(lines, 100*time.Millisecond).Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+	})
+
+	t.Run("ParseLines without success line", func(t *testing.T) {
+		lines := make(chan string, 2)
+		lines <- "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated"
+		lines <- "Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds"
+		close(lines)
+
+		actual, err := ParseLines(lines, 100*time.Millisecond).CollectPartial()
+		assert.ErrorIs(t, err, wrfhours.ErrIncomplete)
+		assert.Equal(t, 1, len(actual))
+	})
+
+	t.Run("SetMetadataOnly", func(t *testing.T) {
+		parser := parseFixture(t, "rsl.out.0000", func(parser *wrfhours.Parser) {
+			parser.SetMetadataOnly(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+
+		assert.Equal(t, wrfhours.RunMetadata{
+			Start:       time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+			LastInstant: time.Date(2021, 8, 6, 0, 0, 0, 0, time.UTC),
+			Completed:   true,
+		}, parser.Metadata())
+	})
+
+	t.Run("success line with trailing spaces", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "success-trailing-spaces")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(actual))
+		assert.True(t, results.CompletedSuccessfully())
+	})
+
+	t.Run("ParseFiles", func(t *testing.T) {
+		summaries, err := ParseFiles(fixtureFS, []string{
+			"rsl.out.0000", "multi-run", "wrong-instant", "aborted", "missing",
+		}, 2)
+
+		var batchErr *BatchError
+		require.ErrorAs(t, err, &batchErr)
+		assert.Equal(t, 3, len(batchErr.Errs))
+		assert.True(t, strings.HasPrefix(err.Error(), "3 logs failed: aborted: input stream completed without success log line"))
+		assert.Error(t, batchErr.Errs["missing"])
+		var formatErr *wrfhours.FormatError
+		assert.ErrorAs(t, batchErr.Errs["wrong-instant"], &formatErr)
+		assert.ErrorIs(t, batchErr.Errs["aborted"], wrfhours.ErrIncomplete)
+
+		require.Equal(t, 4, len(summaries))
+		assert.Equal(t, 201, summaries["rsl.out.0000"].Files)
+		assert.True(t, summaries["rsl.out.0000"].Completed)
+		assert.True(t, summaries["multi-run"].Completed)
+		assert.Equal(t, 1, summaries["aborted"].Files)
+		assert.NotContains(t, summaries, "missing")
+	})
+
+	t.Run("ParseFileTimeout compressed", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		expected, err := results.Collect()
+		require.NoError(t, err)
+
+		for _, name := range []string{"rsl.out.0000.gz", "rsl.out.0000.bz2", "rsl.out.0000.zst"} {
+			results, err := ParseFileTimeout(fixtureFS, name, 10*time.Second)
+			require.NoError(t, err)
+			actual, err := results.Collect()
+			require.NoError(t, err, name)
+			assert.Equal(t, expected, actual, name)
+		}
+	})
+
+	t.Run("ParseFile on corrupt gzip", func(t *testing.T) {
+		_, err := ParseFile(fixtureFS, "not-gzip.gz")
+		assert.EqualError(t, err, "gzip: invalid header")
+	})
+
+	t.Run("SuccessLine", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		assert.Equal(t, "", results.SuccessLine())
+		_, err = results.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, "d01 2021-08-06_00:00:00 wrf: SUCCESS COMPLETE WRF", results.SuccessLine())
+
+		parser := parseFixture(t, "job-done", func(parser *wrfhours.Parser) {
+			parser.SetSuccessLine("JOB DONE")
+		})
+		_, err = parser.Collect()
+		require.NoError(t, err)
+		assert.Equal(t, "JOB DONE", parser.SuccessLine())
+	})
+
+	t.Run("SetJoinWrappedLines", func(t *testing.T) {
+		parser := parseFixture(t, "wrapped-lines", func(parser *wrfhours.Parser) {
+			parser.SetJoinWrappedLines(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 3, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_01:00:00", actual[1].Filename)
+		assert.Equal(t, 11.96844, actual[1].ElapsedSeconds)
+		assert.Equal(t, "wrfout_d01_2021-08-04_02:00:00", actual[2].Filename)
+		assert.Equal(t, 2, actual[2].HourProgr)
+	})
+
+	t.Run("SetJoinWrappedLines on truncated lines", func(t *testing.T) {
+		parser := parseFixture(t, "wrapped-truncated", func(parser *wrfhours.Parser) {
+			parser.SetJoinWrappedLines(true)
+		})
+		actual, err := parser.Collect()
+		require.NoError(t, err)
+		assert.True(t, parser.CompletedSuccessfully())
+
+		require.Equal(t, 4, len(actual))
+		for i, file := range actual {
+			assert.Equal(t, i, file.HourProgr)
+		}
+		assert.Equal(t, 0.47585, actual[2].ElapsedSeconds)
+	})
+
+	t.Run("wrapped lines without SetJoinWrappedLines", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "wrapped-lines")
+		require.NoError(t, err)
+		_, err = results.Collect()
+		var formatErr *wrfhours.FormatError
+		require.ErrorAs(t, err, &formatErr)
+		assert.Equal(t, "Timing for Writing wrfout_d01_2021-08-04_02:0", formatErr.Line)
+	})
+
+	t.Run("SetGroupByDomain", func(t *testing.T) {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		expected, err := results.Collect()
+		require.NoError(t, err)
+
+		results, err = ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		results.SetGroupByDomain(true)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		require.Equal(t, 201, len(actual))
+		assert.Equal(t, wrfhours.FilterFiles(expected, wrfhours.Filter{Domain: 1}), actual[:51])
+		assert.Equal(t, wrfhours.FilterFiles(expected, wrfhours.Filter{Domain: 2}), actual[51:54])
+		assert.Equal(t, wrfhours.FilterFiles(expected, wrfhours.Filter{Domain: 3}), actual[54:])
+	})
+
+	t.Run("SetMetadataOnly on stalled reader", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+
+		parser := wrfhours.NewParser(50 * time.Millisecond)
+		parser.SetMetadataOnly(true)
+		go parser.Parse(r)
+		fmt.Fprintln(w, "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated")
+		fmt.Fprintln(w, "Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds")
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := parser.Collect()
+			errs <- err
+		}()
+
+		select {
+		case err := <-errs:
+			var timeoutErr *wrfhours.TimeoutError
+			assert.ErrorAs(t, err, &timeoutErr)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout not expired")
+		}
+		parser.Stop()
+	})
+
+	t.Run("Collect complete file", func(t *testing.T) {
+
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		require.NoError(t, err)
+		actual, err := results.Collect()
+		require.NoError(t, err)
+
+		checkResults(t, actual)
+
+	})
+
+}
+
+// forkClassifier recognizes timing lines
+// with a nonstandard prefix.
+type forkClassifier struct{}
+
+func (forkClassifier) Classify(line string, started bool) (wrfhours.LineKind, string) {
+	switch {
+	case !started && strings.HasPrefix(line, "d01 "):
+		return wrfhours.StartLine, line
+	case strings.HasPrefix(line, "Timing of Write "):
+		return wrfhours.FileLine, strings.TrimPrefix(line, "Timing of Write ")
+	case strings.HasSuffix(line, "SUCCESS COMPLETE WRF"):
+		return wrfhours.SuccessLine, line
+	}
+	return wrfhours.OtherLine, line
+}
+
+// fakeClock is a wrfhours.Clock whose
+// timers are fired by the test.
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	afters chan fakeTimer
+}
+
+// fakeTimer is a call to fakeClock.After.
+type fakeTimer struct {
+	d time.Duration
+	c chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:    time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		afters: make(chan fakeTimer, 10),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward by d.
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	timer := fakeTimer{d: d, c: make(chan time.Time, 1)}
+	c.afters <- timer
+	return timer.c
+}
+
+// flakyReader reads data failing with consecutive
+// transient errors before the first and the tenth read.
+type flakyReader struct {
+	data     []byte
+	failures int
+	failed   int
+	reads    int
+}
+
+// parseFixture starts parsing the named fixture with a
+// parser configured by opts, and returns the parser.
+func parseFixture(t *testing.T, name string, opts ...func(*wrfhours.Parser)) *wrfhours.Parser {
+	file, err := fixtureFS.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+
+	parser := wrfhours.NewParser(100 * time.Millisecond)
+	for _, opt := range opts {
+		opt(parser)
+	}
+	go parser.Parse(file)
+	return parser
+}
+
+// assertNoLeak asserts the number of goroutines
+// returns to before within a second.
+func assertNoLeak(t *testing.T, before int) {
+	// assert.Eventually can't be used here, since it
+	// evaluates the condition in a new goroutine.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+// temporaryError is a transient error, like some net.Error.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary failure" }
+func (temporaryError) Temporary() bool { return true }
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if (r.reads == 0 || r.reads == 9) && r.failed < r.failures {
+		r.failed++
+		if r.failed == 1 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, temporaryError{}
+	}
+	r.failed = 0
+	r.reads++
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+var openMetricsSample = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)((?:\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*")*)?\})?) (\S+)$`)
+
+// parseOpenMetrics parses a counters only OpenMetrics text exposition,
+// returning the value of each sample keyed by its name and labelset.
+// It fails on the syntax errors and on the samples the specification
+// forbids: without a family, out of their family, or repeated.
+func parseOpenMetrics(text string) (map[string]float64, error) {
+	if !strings.HasSuffix(text, "# EOF\n") {
+		return nil, errors.New("missing # EOF line")
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "# EOF\n"), "\n")
+	lines = lines[:len(lines)-1]
+
+	samples := map[string]float64{}
+	families := map[string]bool{}
+	family := ""
+	for n, line := range lines {
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 || fields[3] != "counter" {
+				return nil, fmt.Errorf("line %d: unsupported TYPE line", n+1)
+			}
+			if families[fields[2]] {
+				return nil, fmt.Errorf("line %d: interleaved family %s", n+1, fields[2])
+			}
+			family = fields[2]
+			families[family] = true
+			continue
+		}
+		match := openMetricsSample.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("line %d: invalid sample", n+1)
+		}
+		if family == "" || (match[1] != family+"_total" && match[1] != family+"_created") {
+			return nil, fmt.Errorf("line %d: sample %s out of its family", n+1, match[1])
+		}
+		value, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value: %w", n+1, err)
+		}
+		key := match[1] + match[2]
+		if _, ok := samples[key]; ok {
+			return nil, fmt.Errorf("line %d: repeated sample %s", n+1, key)
+		}
+		samples[key] = value
+	}
+	return samples, nil
+}
+
+// recordingWriter records the data of each Write.
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (n int, err error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+type failingWriter struct{}
+
+func (w failingWriter) Write(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("TEST")
+}
+
+func checkResults(t *testing.T, actual []wrfhours.FileInfo) {
+	assert.Equal(t, 201, len(actual))
+
+	assert.Equal(t, wrfhours.FileInfo{
+		Type:           "wrfout",
+		Domain:         1,
+		Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		Filename:       "wrfout_d01_2021-08-04_00:00:00",
+		HourProgr:      0,
+		Elapsed:        475850 * time.Microsecond,
+		ElapsedSeconds: 0.47585,
+	}, actual[0])
+
+	assert.Equal(t, wrfhours.FileInfo{
+		Type:           "wrfout",
+		Domain:         3,
+		Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+		Filename:       "wrfout_d03_2021-08-04_01:00:00",
+		HourProgr:      1,
+		Elapsed:        895550 * time.Microsecond,
+		ElapsedSeconds: 0.89555,
+	}, actual[10])
+
+	assert.Equal(t, wrfhours.FileInfo{
+		Type:           "auxhist23",
+		Domain:         3,
+		Instant:        time.Date(2021, 8, 5, 23, 0, 0, 0, time.UTC),
+		Filename:       "auxhist23_d03_2021-08-05_23:00:00",
+		HourProgr:      47,
+		Elapsed:        165560 * time.Microsecond,
+		ElapsedSeconds: 0.16556,
+	}, actual[196])
+}
+
+func BenchmarkNewParser(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		parser := wrfhours.NewParser(time.Second)
+		parser.Close()
+		<-parser.Files
+	}
+}
+
+func BenchmarkNewParserNoTimeout(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		parser := wrfhours.NewParserNoTimeout()
+		parser.Close()
+		<-parser.Files
+	}
+}
+
+// BenchmarkParserGoroutines reports the
+// goroutines started by each kind of parser.
+func BenchmarkParserGoroutines(b *testing.B) {
+	constructors := []struct {
+		name string
+		new  func() *wrfhours.Parser
+	}{
+		{"NewParser", func() *wrfhours.Parser { return wrfhours.NewParser(time.Second) }},
+		{"NewParserNoTimeout", wrfhours.NewParserNoTimeout},
+	}
+	for _, c := range constructors {
+		b.Run(c.name, func(b *testing.B) {
+			parsers := make([]*wrfhours.Parser, b.N)
+			before := runtime.NumGoroutine()
+			for i := range parsers {
+				parsers[i] = c.new()
+			}
+			b.ReportMetric(float64(runtime.NumGoroutine()-before)/float64(b.N), "goroutines/op")
+
+			b.StopTimer()
+			for _, parser := range parsers {
+				parser.Close()
+				<-parser.Files
+			}
+		})
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		results, err := ParseFile(fixtureFS, "rsl.out.0000")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := results.Collect(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMetadataOnly(b *testing.B) {
+	content, err := fs.ReadFile(fixtureFS, "rsl.out.0000")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		parser := wrfhours.NewParser(time.Second)
+		parser.SetMetadataOnly(true)
+		go parser.Parse(bytes.NewReader(content))
+		if _, err := parser.Collect(); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
@@ -2,74 +2,268 @@ package json
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/meteocima/wrfhours"
 )
 
 // Marshal ...
+//
+// Each file is written as a single JSON line using one
+// Write call, so when Marshal fails midway, e.g. on a wrong
+// timing line, out contains only the complete lines of the
+// files emitted before the error, and can still be read
+// back with Unmarshal.
 func Marshal(in io.Reader, out io.Writer, timeout time.Duration) error {
+	return MarshalContext(context.Background(), in, out, timeout)
+}
+
+// MarshalContext works like Marshal, but stops when ctx
+// is done, returning ctx.Err().
+func MarshalContext(ctx context.Context, in io.Reader, out io.Writer, timeout time.Duration) error {
+	return marshal(ctx, in, out, timeout, func(wrfhours.FileInfo) bool { return true }, MarshalOptions{})
+}
+
+// MarshalFiltered works like Marshal, but writes
+// only the files matching filter.
+func MarshalFiltered(in io.Reader, out io.Writer, timeout time.Duration, filter wrfhours.Filter) error {
+	return marshal(context.Background(), in, out, timeout, filter.Matches, MarshalOptions{})
+}
+
+// MarshalOptions are the options of MarshalWithOptions.
+type MarshalOptions struct {
+	// Pretty enables writing each file as an indented
+	// JSON object spanning multiple lines, in place of
+	// a single line. Unmarshal can read both formats.
+	Pretty bool
+}
+
+// MarshalWithOptions works like Marshal, but formats
+// the files as specified by opts. The fields of each
+// file are always written in the same order.
+func MarshalWithOptions(in io.Reader, out io.Writer, timeout time.Duration, opts MarshalOptions) error {
+	return marshal(context.Background(), in, out, timeout, func(wrfhours.FileInfo) bool { return true }, opts)
+}
+
+// marshal writes the files for which match returns true,
+// formatted as specified by opts.
+func marshal(ctx context.Context, in io.Reader, out io.Writer, timeout time.Duration, match func(wrfhours.FileInfo) bool, opts MarshalOptions) error {
 	parser := wrfhours.NewParser(timeout)
+	defer parser.Stop()
 
 	go parser.Parse(in)
 
-	for file := range parser.Files {
+	for {
+		var file wrfhours.FileInfo
+		var ok bool
+		select {
+		case file, ok = <-parser.Files:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !ok {
+			break
+		}
 		if file.Err != nil {
 			return file.Err
 		}
-		buff, err := json.Marshal(file)
+		if file.Done || !match(file) {
+			continue
+		}
+		var buff []byte
+		var err error
+		if opts.Pretty {
+			buff, err = json.MarshalIndent(file, "", "  ")
+		} else {
+			buff, err = json.Marshal(file)
+		}
 		if err != nil {
 			return err
 		}
 
-		if _, err = fmt.Fprintln(out, string(buff)); err != nil {
+		if _, err = out.Write(append(buff, '\n')); err != nil {
 			return fmt.Errorf("Marshal failed: error while writing: %w", err)
 		}
 	}
 
-	//fmt.Println("MARSHAL DONE")
-
 	return nil
 }
 
 // Unmarshal parse results of wrfoutput command
-// and unmarshal it into a channel of FileInfo structs
+// and unmarshal it into a channel of FileInfo structs.
+// Blank lines and lines starting with `#` are skipped.
+// Besides one record per line, records formatted on
+// multiple lines, e.g. pretty printed, are accepted.
 func Unmarshal(r io.Reader) *wrfhours.Parser {
 	results := wrfhours.NewParser(time.Second)
 
-	go func() {
-		var err error
+	go unmarshal(r, results, func(file wrfhours.FileInfo) bool { return true })
+
+	return results
+}
+
+// UnmarshalCloser works like Unmarshal, but closes rc once
+// all the results have been read, e.g. to release the file or
+// the network connection they are read from. An error
+// returned by rc.Close is emitted at the end of the stream.
+func UnmarshalCloser(rc io.ReadCloser) *wrfhours.Parser {
+	results := wrfhours.NewParser(time.Second)
+	results.SetOnClose(rc.Close)
+
+	go unmarshal(rc, results, func(file wrfhours.FileInfo) bool { return true })
 
-		scanner := bufio.NewScanner(r)
-		for scanner.Scan() {
-			line := scanner.Bytes()
-			var file wrfhours.FileInfo
-			// fmt.Printlnln("unmarshal")
-			err = json.Unmarshal(line, &file)
-			if err != nil {
-				// fmt.Printlnln("err found")
-				break
+	return results
+}
+
+// Replay reads results of wrfoutput command previously
+// saved with discovery timestamps recorded, and re-emits them
+// with the same delays that occurred between their discovery
+// during the original run, scaled by speed: a speed of 2 replays
+// the records twice as fast as the original run.
+// Records without a discovery timestamp, or a speed <= 0,
+// are emitted without waiting.
+func Replay(r io.Reader, speed float64) *wrfhours.Parser {
+	// delays between records can be arbitrarily long,
+	// so the timeout is disabled.
+	results := wrfhours.NewParserNoTimeout()
+
+	var last *time.Time
+	go unmarshal(r, results, func(file wrfhours.FileInfo) bool {
+		if file.Discovered == nil {
+			return true
+		}
+		if last != nil && speed > 0 {
+			gap := file.Discovered.Sub(*last)
+			timer := time.NewTimer(time.Duration(float64(gap) / speed))
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-results.Stopped():
+				return false
 			}
-			results.EmitFile(file)
 		}
-		if err == nil {
-			// fmt.Printlnln("err nil, check scanner")
-			err = scanner.Err()
+		last = file.Discovered
+		return true
+	})
+
+	return results
+}
+
+// unmarshal reads results from r and emit them using results parser.
+// Records can be either on a single line or span multiple ones.
+// wait is called before each record is emitted, and returns
+// false when the parser has been stopped while waiting.
+// Reading stops as soon as the parser is stopped.
+func unmarshal(r io.Reader, results *wrfhours.Parser, wait func(file wrfhours.FileInfo) bool) {
+	var err error
+	lineNum := 0
+
+	lines := &commentSkipper{r: bufio.NewReader(r)}
+	dec := json.NewDecoder(lines)
+	for {
+		// More skips the whitespace preceding
+		// the record, so that InputOffset points
+		// to its first byte.
+		dec.More()
+		lineNum = lines.lineAt(dec.InputOffset())
+
+		var file wrfhours.FileInfo
+		if err = dec.Decode(&file); err != nil {
+			if err == io.EOF {
+				err = nil
+			} else if err == io.ErrUnexpectedEOF {
+				err = errTruncated
+			}
+			break
 		}
+		lines.forget(dec.InputOffset())
+		if !wait(file) || !results.EmitFile(file) {
+			break
+		}
+	}
 
-		if err != nil {
-			// fmt.Printlnln("err!")
-			err = fmt.Errorf("Unmarshal failed: error while reading: %w", err)
-			// fmt.Printlnln(err)
-			results.EmitError(err)
-			return
+	if err != nil {
+		err = fmt.Errorf("Unmarshal failed: error while reading line %d: %w", lineNum, err)
+	}
+	results.Finish(err)
+}
+
+// errTruncated is reported for a record truncated at the end of the
+// input, with the same wording used by json.Unmarshal.
+var errTruncated = errors.New("unexpected end of JSON input")
+
+// commentSkipper is a reader that skips the lines
+// of r starting with `#`, keeping track of the
+// original number of the lines it returns.
+type commentSkipper struct {
+	r       *bufio.Reader
+	pending []byte
+	err     error
+	// read is the number of bytes returned.
+	read int64
+	// lineNum is the number of lines read from r.
+	lineNum int
+	// starts contains the offset in the returned
+	// bytes of each line not skipped, and nums
+	// contains the numbers of those lines in r.
+	starts []int64
+	nums   []int
+}
+
+func (s *commentSkipper) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		var line []byte
+		line, s.err = s.r.ReadBytes('\n')
+		if len(line) == 0 {
+			continue
+		}
+		s.lineNum++
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '#' {
+			continue
 		}
-		// // fmt.Printlnln("err nil close files")
-		results.Close()
-	}()
+		s.starts = append(s.starts, s.read)
+		s.nums = append(s.nums, s.lineNum)
+		s.pending = line
+	}
 
-	return results
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	s.read += int64(n)
+	return n, nil
+}
+
+// forget drops the offsets of the lines preceding
+// the one containing the byte at offset, so that
+// memory doesn't grow with the length of r.
+func (s *commentSkipper) forget(offset int64) {
+	i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > offset }) - 1
+	if i <= 0 {
+		return
+	}
+	s.starts = s.starts[:copy(s.starts, s.starts[i:])]
+	s.nums = s.nums[:copy(s.nums, s.nums[i:])]
+}
+
+// lineAt returns the number in r of the
+// line containing the byte at offset.
+func (s *commentSkipper) lineAt(offset int64) int {
+	i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > offset }) - 1
+	if i < 0 {
+		return s.lineNum + 1
+	}
+	if offset >= s.read && s.err != nil {
+		// the record would start after the end of r.
+		return s.lineNum + 1
+	}
+	return s.nums[i]
 }
@@ -1,10 +1,15 @@
 package json
 
 import (
+	"bufio"
+	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,10 +38,110 @@ func TestParseFile(t *testing.T) {
 		f := <-results.Files
 		require.NotNil(t, f)
 
-		assert.EqualError(t, f.Err, "Unmarshal failed: error while reading: invalid character 'T' looking for beginning of value")
+		assert.EqualError(t, f.Err, "Unmarshal failed: error while reading line 1: invalid character 'T' looking for beginning of value")
 
 	})
 
+	t.Run("Unmarshal skips blank and comment lines", func(t *testing.T) {
+
+		r := strings.NewReader(`# captured from run 42
+{"Type":"wrfout","Domain":1}
+
+   
+  # another comment
+{"Type":"wrfout","Domain":2}
+`)
+
+		actual, err := Unmarshal(r).Collect()
+		require.NoError(t, err)
+		assert.Equal(t, []wrfhours.FileInfo{
+			{Type: "wrfout", Domain: 1},
+			{Type: "wrfout", Domain: 2},
+		}, actual)
+
+	})
+
+	t.Run("Unmarshal reports the corrupt line", func(t *testing.T) {
+
+		r := strings.NewReader(`{"Type":"wrfout","Domain":1}
+{"Type":"wrfout","Domain":2}
+{"Type":"wrfout","Domain":
+{"Type":"wrfout","Domain":3}
+`)
+
+		actual, err := Unmarshal(r).Collect()
+		assert.Nil(t, actual)
+		// the truncated record continues on
+		// the following line, up to the end.
+		assert.EqualError(t, err, "Unmarshal failed: error while reading line 3: unexpected end of JSON input")
+
+	})
+
+	t.Run("Unmarshal reports the corrupt line of a long stream", func(t *testing.T) {
+
+		record := `{"Type":"wrfout","Domain":1}` + "\n"
+		r := strings.NewReader(strings.Repeat(record+"# comment\n", 1000) + "TEST\n")
+
+		actual, err := Unmarshal(r).CollectPartial()
+		assert.Equal(t, 1000, len(actual))
+		assert.EqualError(t, err, "Unmarshal failed: error while reading line 2001: invalid character 'T' looking for beginning of value")
+
+	})
+
+	t.Run("Unmarshal multi-line records", func(t *testing.T) {
+
+		r := strings.NewReader(`{"Type":"wrfout","Domain":1}
+# pretty printed
+{
+  "Type": "wrfout",
+  # a comment inside the record
+  "Domain": 2
+}
+{"Type":"wrfout","Domain":3} {"Type":"wrfout","Domain":4}
+{
+  "Type": "wrfout",
+  "Domain": "5"
+}
+`)
+
+		actual, err := Unmarshal(r).CollectPartial()
+		assert.Equal(t, []wrfhours.FileInfo{
+			{Type: "wrfout", Domain: 1},
+			{Type: "wrfout", Domain: 2},
+			{Type: "wrfout", Domain: 3},
+			{Type: "wrfout", Domain: 4},
+		}, actual)
+		assert.EqualError(t, err, "Unmarshal failed: error while reading line 9: json: cannot unmarshal string into Go struct field FileInfo.Domain of type int")
+
+	})
+
+	t.Run("UnmarshalCloser", func(t *testing.T) {
+		rc := &trackingCloser{Reader: strings.NewReader(`{"Type":"wrfout","Domain":1}` + "\n")}
+		actual, err := UnmarshalCloser(rc).Collect()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(actual))
+		assert.True(t, rc.closed)
+	})
+
+	t.Run("Unmarshal closes StepTimings", func(t *testing.T) {
+		results := Unmarshal(strings.NewReader(`{"Type":"wrfout","Domain":1}` + "\n"))
+		_, err := results.Collect()
+		require.NoError(t, err)
+
+		select {
+		case _, ok := <-results.StepTimings():
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("StepTimings not closed")
+		}
+	})
+
+	t.Run("UnmarshalCloser on failing close", func(t *testing.T) {
+		rc := &trackingCloser{Reader: strings.NewReader(""), err: fmt.Errorf("TEST")}
+		_, err := UnmarshalCloser(rc).Collect()
+		assert.EqualError(t, err, "OnClose hook failed: TEST")
+	})
+
 	t.Run("Marshal / Unmarshal", func(t *testing.T) {
 
 		file, err := fixtureFS.Open("rsl.out.0000")
@@ -72,6 +177,181 @@ func TestParseFile(t *testing.T) {
 
 	})
 
+	t.Run("Marshal leaves parseable output on error", func(t *testing.T) {
+		in := strings.Join([]string{
+			"d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated",
+			"Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds",
+			"Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds",
+			"Timing for Writing wrfout_d01_2021-08-04_02:00:00 for domain        1:    0.47585 elapsed seconds",
+			"Timing for Writing wrfout_d01_2021-08-RR_03:00:00 for domain        1:    0.47585 elapsed seconds",
+		}, "\n")
+
+		var out strings.Builder
+		err := Marshal(strings.NewReader(in), &out, 100*time.Millisecond)
+		var formatErr *wrfhours.FormatError
+		require.ErrorAs(t, err, &formatErr)
+
+		actual, err := Unmarshal(strings.NewReader(out.String())).Collect()
+		require.NoError(t, err)
+		require.Equal(t, 3, len(actual))
+		assert.Equal(t, "wrfout_d01_2021-08-04_02:00:00", actual[2].Filename)
+	})
+
+	t.Run("MarshalWithOptions pretty", func(t *testing.T) {
+
+		file, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer file.Close()
+
+		var out strings.Builder
+		err = MarshalWithOptions(file, &out, 100*time.Millisecond, MarshalOptions{Pretty: true})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(out.String(), "{\n  \"Type\": \"wrfout\",\n  \"Domain\": 1,\n"))
+
+		actual, err := Unmarshal(strings.NewReader(out.String())).Collect()
+		require.NoError(t, err)
+		checkResults(t, actual)
+
+	})
+
+	t.Run("MarshalFiltered", func(t *testing.T) {
+		file, err := fixtureFS.Open("rsl.out.0000")
+		require.NoError(t, err)
+		defer file.Close()
+
+		var out strings.Builder
+		err = MarshalFiltered(file, &out, 100*time.Millisecond, wrfhours.Filter{Type: "wrfout", Domain: 3})
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		assert.Equal(t, 49, len(lines))
+		for _, line := range lines {
+			assert.Contains(t, line, `"Filename":"wrfout_d03_`)
+		}
+	})
+
+	t.Run("MarshalContext cancelled", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		go fmt.Fprint(w, `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
+Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds
+`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		out := cancellingWriter{cancel: cancel}
+
+		err := MarshalContext(ctx, r, &out, time.Second)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, out.writes)
+	})
+
+}
+
+func TestCommentSkipper(t *testing.T) {
+	lines := &commentSkipper{r: bufio.NewReader(strings.NewReader("{}\n# comment\n{}\n{}\n"))}
+	dec := json.NewDecoder(lines)
+	for i := 0; i < 3; i++ {
+		var v struct{}
+		require.NoError(t, dec.Decode(&v))
+		lines.forget(dec.InputOffset())
+	}
+	// only the line containing the offset is kept.
+	assert.Equal(t, []int{4}, lines.nums)
+	assert.Equal(t, 4, lines.lineAt(dec.InputOffset()))
+}
+
+func TestReplay(t *testing.T) {
+	first := time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC)
+	second := first.Add(100 * time.Millisecond)
+
+	r, w := io.Pipe()
+	go func() {
+		defer w.Close()
+		for _, discovered := range []time.Time{first, second} {
+			discovered := discovered
+			buff, err := json.Marshal(wrfhours.FileInfo{
+				Type:       "wrfout",
+				Domain:     1,
+				Discovered: &discovered,
+			})
+			require.NoError(t, err)
+			fmt.Fprintln(w, string(buff))
+		}
+	}()
+
+	results := Replay(r, 2)
+
+	f := <-results.Files
+	require.NoError(t, f.Err)
+	assert.Equal(t, first, f.Discovered.UTC())
+	emitted := time.Now()
+
+	f = <-results.Files
+	require.NoError(t, f.Err)
+	assert.Equal(t, second, f.Discovered.UTC())
+	delay := time.Since(emitted)
+
+	assert.GreaterOrEqual(t, int64(delay), int64(45*time.Millisecond))
+	assert.Less(t, int64(delay), int64(100*time.Millisecond))
+
+	_, ok := <-results.Files
+	assert.False(t, ok)
+}
+
+func TestReplayStopped(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var records strings.Builder
+	for _, discovered := range []time.Time{
+		time.Date(2021, 8, 4, 10, 0, 0, 0, time.UTC),
+		time.Date(2021, 8, 4, 11, 0, 0, 0, time.UTC),
+	} {
+		discovered := discovered
+		buff, err := json.Marshal(wrfhours.FileInfo{Type: "wrfout", Domain: 1, Discovered: &discovered})
+		require.NoError(t, err)
+		fmt.Fprintln(&records, string(buff))
+	}
+
+	results := Replay(strings.NewReader(records.String()), 1)
+	f := <-results.Files
+	require.NoError(t, f.Err)
+
+	// the next record is an hour later.
+	results.Stop()
+	assertNoLeak(t, before)
+}
+
+func TestUnmarshalStopped(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	results := Unmarshal(&repeatReader{data: []byte(`{"Type":"wrfout","Domain":1}` + "\n")})
+	f := <-results.Files
+	require.NoError(t, f.Err)
+
+	results.Stop()
+	assertNoLeak(t, before)
+}
+
+// repeatReader reads data repeated endlessly.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+func assertNoLeak(t *testing.T, before int) {
+	// assert.Eventually can't be used here, since it
+	// evaluates the condition in a new goroutine.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
 }
 
 type failingWriter struct{}
@@ -84,26 +364,58 @@ func checkResults(t *testing.T, actual []wrfhours.FileInfo) {
 	assert.Equal(t, 201, len(actual))
 
 	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "wrfout",
-		Domain:    1,
-		Instant:   time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
-		Filename:  "wrfout_d01_2021-08-04_00:00:00",
-		HourProgr: 0,
+		Type:           "wrfout",
+		Domain:         1,
+		Instant:        time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC),
+		Filename:       "wrfout_d01_2021-08-04_00:00:00",
+		HourProgr:      0,
+		Elapsed:        475850 * time.Microsecond,
+		ElapsedSeconds: 0.47585,
 	}, actual[0])
 
 	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "wrfout",
-		Domain:    3,
-		Instant:   time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
-		Filename:  "wrfout_d03_2021-08-04_01:00:00",
-		HourProgr: 1,
+		Type:           "wrfout",
+		Domain:         3,
+		Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+		Filename:       "wrfout_d03_2021-08-04_01:00:00",
+		HourProgr:      1,
+		Elapsed:        895550 * time.Microsecond,
+		ElapsedSeconds: 0.89555,
 	}, actual[10])
 
 	assert.Equal(t, wrfhours.FileInfo{
-		Type:      "auxhist23",
-		Domain:    3,
-		Instant:   time.Date(2021, 8, 5, 23, 0, 0, 0, time.UTC),
-		Filename:  "auxhist23_d03_2021-08-05_23:00:00",
-		HourProgr: 47,
+		Type:           "auxhist23",
+		Domain:         3,
+		Instant:        time.Date(2021, 8, 5, 23, 0, 0, 0, time.UTC),
+		Filename:       "auxhist23_d03_2021-08-05_23:00:00",
+		HourProgr:      47,
+		Elapsed:        165560 * time.Microsecond,
+		ElapsedSeconds: 0.16556,
 	}, actual[196])
 }
+
+// trackingCloser records whether it has been
+// closed, and returns err when it is.
+type trackingCloser struct {
+	io.Reader
+	closed bool
+	err    error
+}
+
+func (c *trackingCloser) Close() error {
+	c.closed = true
+	return c.err
+}
+
+// cancellingWriter cancels a context
+// after the first write.
+type cancellingWriter struct {
+	cancel context.CancelFunc
+	writes int
+}
+
+func (w *cancellingWriter) Write(p []byte) (n int, err error) {
+	w.writes++
+	w.cancel()
+	return len(p), nil
+}
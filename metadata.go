@@ -0,0 +1,63 @@
+package wrfhours
+
+import "time"
+
+// RunMetadata contains the information about a
+// WRF run collected in metadata only mode.
+type RunMetadata struct {
+	// Start is the first instant of the simulation.
+	Start time.Time
+	// LastInstant is the instant of the
+	// last file written by WRF.
+	LastInstant time.Time
+	// Completed reports whether the
+	// success line was found in the log.
+	Completed bool
+}
+
+// SetMetadataOnly enables or disables the metadata only
+// mode. In this mode timing lines are parsed only to keep
+// track of the instant of the last file written, and no file
+// is emitted: read the results with Metadata once the
+// stream of files is closed. Use it when only the start, the
+// end and the outcome of a run are needed, to spare emitting
+// and collecting the files. Most of the time is still spent
+// reading the log, though. It must be called before Parse.
+func (parser *Parser) SetMetadataOnly(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.metadataOnly = enabled
+}
+
+// Metadata returns the information about the run
+// collected in metadata only mode. It must be
+// called after the stream of files is closed.
+func (parser *Parser) Metadata() RunMetadata {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+
+	metadata := RunMetadata{
+		LastInstant: parser.lastInstant,
+		Completed:   parser.completed,
+	}
+	if parser.Start != nil {
+		metadata.Start = *parser.Start
+	}
+	return metadata
+}
+
+// recordLastInstant parse a timing line, keeping track
+// of the instant of its file, and restarts the timeout.
+func (parser *Parser) recordLastInstant(text string) error {
+	info := parser.parseFileInfo(text)
+	if info.Err != nil {
+		return info.Err
+	}
+	// restart files have no instant.
+	if !info.Instant.IsZero() {
+		parser.lock.Lock()
+		parser.lastInstant = info.Instant
+		parser.lock.Unlock()
+	}
+	return parser.signalProgress()
+}
@@ -0,0 +1,65 @@
+package wrfhours
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricStream identifies the type
+// and domain of the files counted.
+type metricStream struct {
+	typ    string
+	domain int
+}
+
+// WriteOpenMetrics reads files from the channel and, as each file
+// arrives, writes to out an updated OpenMetrics text exposition
+// of the `wrf_file_written_total` counters of each type and domain,
+// terminated by the `# EOF` line. Each exposition is written with
+// a single Write, and contains one sample per labelset, sorted by
+// type and domain. It returns the first error emitted on the
+// channel, or encountered while writing.
+func WriteOpenMetrics(files <-chan FileInfo, out io.Writer) error {
+	counts := map[metricStream]int{}
+	var streams []metricStream
+	for file := range files {
+		if file.Err != nil {
+			return file.Err
+		}
+		if file.Done {
+			continue
+		}
+
+		stream := metricStream{typ: file.Type, domain: file.Domain}
+		if counts[stream] == 0 {
+			streams = append(streams, stream)
+			sort.Slice(streams, func(i, j int) bool {
+				if streams[i].typ != streams[j].typ {
+					return streams[i].typ < streams[j].typ
+				}
+				return streams[i].domain < streams[j].domain
+			})
+		}
+		counts[stream]++
+
+		var exposition strings.Builder
+		exposition.WriteString("# TYPE wrf_file_written counter\n")
+		for _, stream := range streams {
+			fmt.Fprintf(
+				&exposition,
+				"wrf_file_written_total{type=\"%s\",domain=\"%d\"} %d\n",
+				labelEscaper.Replace(stream.typ), stream.domain, counts[stream],
+			)
+		}
+		exposition.WriteString("# EOF\n")
+
+		if _, err := io.WriteString(out, exposition.String()); err != nil {
+			return fmt.Errorf("WriteOpenMetrics failed: error while writing: %w", err)
+		}
+	}
+	return nil
+}
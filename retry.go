@@ -0,0 +1,54 @@
+package wrfhours
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// retryReader is a reader retrying
+// reads failed with transient errors.
+type retryReader struct {
+	r       io.Reader
+	retries int
+	backoff time.Duration
+	clock   Clock
+	done    chan struct{}
+	// failures is the number of
+	// consecutive failed reads.
+	failures int
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := rr.r.Read(p)
+		if err == nil || !isTransient(err) {
+			rr.failures = 0
+			return n, err
+		}
+		if n > 0 {
+			rr.failures = 0
+			return n, nil
+		}
+		if rr.failures >= rr.retries {
+			return n, err
+		}
+		rr.failures++
+
+		select {
+		case <-rr.clock.After(rr.backoff):
+		case <-rr.done:
+			return n, err
+		}
+	}
+}
+
+// isTransient reports whether a read
+// failed with err could succeed if retried.
+func isTransient(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
@@ -0,0 +1,69 @@
+package wrfhours
+
+import "io"
+
+// Scanner reads the files of a WRF log one by one,
+// for callers preferring a pull API to channels:
+//
+//	s := wrfhours.NewScanner(r)
+//	for s.Scan() {
+//		f := s.File()
+//		...
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+//
+// The Scanner has no timeout: a Scan call blocks
+// until the next file is read from r.
+type Scanner struct {
+	parser *Parser
+	file   FileInfo
+	err    error
+}
+
+// NewScanner returns a Scanner reading from r.
+func NewScanner(r io.Reader) *Scanner {
+	parser := NewParserNoTimeout()
+	go parser.Parse(r)
+	return &Scanner{parser: parser}
+}
+
+// Scan advances the Scanner to the next file, which
+// is then available through File. It returns false
+// when the log ends or when it fails: Err returns
+// the error in the latter case.
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	for file := range s.parser.Files {
+		if file.Err != nil {
+			s.err = file.Err
+			return false
+		}
+		if file.Done {
+			continue
+		}
+		s.file = file
+		return true
+	}
+	return false
+}
+
+// File returns the file read by the last Scan call.
+func (s *Scanner) File() FileInfo {
+	return s.file
+}
+
+// Err returns the error that stopped
+// the Scanner, if any.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Stop releases the Scanner when the
+// caller abandons it before the end.
+func (s *Scanner) Stop() {
+	s.parser.Stop()
+}
@@ -0,0 +1,98 @@
+package wrfhours
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StepTiming is the time spent by WRF
+// integrating a single time step of a domain.
+type StepTiming struct {
+	// Instant is the simulated instant
+	// reached at the end of the step.
+	Instant time.Time
+	Domain  int
+	Elapsed time.Duration
+}
+
+// parseStepTiming parses the part of a step timing line
+// following `Timing for main`, e.g.
+// ` (dt=  4.00): time 2021-08-04_00:00:04 on domain   3:    1.60554 elapsed seconds`.
+// The `(dt=...)` part is missing in some WRF versions.
+func parseStepTiming(text string) (StepTiming, error) {
+	timeIdx := strings.Index(text, ": time ")
+	if timeIdx == -1 {
+		return StepTiming{}, fmt.Errorf("`: time` expected to appears in line")
+	}
+	parts := strings.SplitN(text[timeIdx+len(": time "):], " on domain", 2)
+	if len(parts) != 2 {
+		return StepTiming{}, fmt.Errorf("`on domain` expected to appears in line")
+	}
+
+	instant, err := time.Parse("2006-01-02_15:04:05", strings.TrimSpace(parts[0]))
+	if err != nil {
+		return StepTiming{}, err
+	}
+
+	domainParts := strings.SplitN(parts[1], ":", 2)
+	if len(domainParts) != 2 {
+		return StepTiming{}, fmt.Errorf("elapsed seconds expected after `on domain N:`")
+	}
+	domain, err := strconv.Atoi(strings.TrimSpace(domainParts[0]))
+	if err != nil {
+		return StepTiming{}, fmt.Errorf("invalid domain: %w", err)
+	}
+
+	seconds, err := parseElapsed(domainParts[1])
+	if err != nil {
+		return StepTiming{}, err
+	}
+
+	return StepTiming{
+		Instant: instant,
+		Domain:  domain,
+		Elapsed: secondsToDuration(seconds),
+	}, nil
+}
+
+// SetEmitStepTiming enables the emission of the
+// time spent on each integration step on the
+// StepTimings channel. It's disabled by default.
+// It must be called before Parse.
+func (parser *Parser) SetEmitStepTiming(emit bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.emitStepTiming = emit
+}
+
+// StepTimings returns the channel on which step timings are
+// emitted when enabled with SetEmitStepTiming. When enabled,
+// the channel must be read concurrently with Files in order
+// for the parsing to proceed. It's closed with the stream of
+// files, also when it's fed with EmitFile and Finish.
+func (parser *Parser) StepTimings() <-chan StepTiming {
+	return parser.steps
+}
+
+// EstimateCompletion estimates the time needed to complete a
+// simulation of totalSteps integration steps, given the steps
+// observed so far, by extrapolating their average elapsed time.
+// It fails when less than two steps have been observed.
+func EstimateCompletion(steps []StepTiming, totalSteps int) (time.Duration, error) {
+	if len(steps) < 2 {
+		return 0, fmt.Errorf("at least 2 step timings are needed, got %d", len(steps))
+	}
+
+	remaining := totalSteps - len(steps)
+	if remaining <= 0 {
+		return 0, nil
+	}
+
+	var total time.Duration
+	for _, step := range steps {
+		total += step.Elapsed
+	}
+	return total / time.Duration(len(steps)) * time.Duration(remaining), nil
+}
@@ -0,0 +1,78 @@
+package wrfhours
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// RunSummary contains aggregated information
+// about a WRF run, suitable for a run catalog.
+type RunSummary struct {
+	// Start is the first instant of the simulation.
+	Start time.Time
+	// End is the greatest instant of the files written.
+	End time.Time
+	// Domains contains the sorted list of
+	// domains for which files were written.
+	Domains []int
+	// Types contains the sorted list of
+	// types of files written.
+	Types []string
+	// Files is the total number of files written.
+	Files int
+	// FilesByType contains the number
+	// of files written for each type.
+	FilesByType map[string]int
+	// Completed reports whether the
+	// success line was found in the log.
+	Completed bool
+}
+
+// Summarize parses a WRF log from r and aggregates
+// the results in a RunSummary. When parsing fails,
+// it returns the error together with the summary of
+// what was parsed until then.
+func Summarize(r io.Reader, timeout time.Duration) (*RunSummary, error) {
+	parser := NewParser(timeout)
+	defer parser.Stop()
+	go parser.Parse(r)
+
+	summary := &RunSummary{
+		Domains:     []int{},
+		Types:       []string{},
+		FilesByType: map[string]int{},
+	}
+
+	var err error
+	domains := map[int]bool{}
+	for event := range parser.Events() {
+		switch event.Kind {
+		case StartEvent:
+			summary.Start = event.Start
+		case FileEvent:
+			file := event.File
+			summary.Files++
+			if summary.FilesByType[file.Type] == 0 {
+				summary.Types = append(summary.Types, file.Type)
+			}
+			summary.FilesByType[file.Type]++
+			if !domains[file.Domain] {
+				domains[file.Domain] = true
+				summary.Domains = append(summary.Domains, file.Domain)
+			}
+			if file.Instant.After(summary.End) {
+				summary.End = file.Instant
+			}
+		case SuccessEvent:
+			summary.Completed = true
+		case ErrorEvent:
+			err = event.Err
+		}
+	}
+
+	sort.Ints(summary.Domains)
+	sort.Strings(summary.Types)
+
+	return summary, err
+}
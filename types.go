@@ -0,0 +1,30 @@
+package wrfhours
+
+import "strings"
+
+// Types of the files written by WRF. Auxiliary history
+// streams are numbered, e.g. `auxhist23`: TypeAuxhist is
+// their type without the number.
+const (
+	TypeWrfout  = "wrfout"
+	TypeAuxhist = "auxhist"
+	// TypeRestart is the type of restart files,
+	// which are not emitted by the parser.
+	TypeRestart = "restart"
+)
+
+// KnownType reports whether typ is one of the known
+// types of files, ignoring the number of auxiliary streams.
+func KnownType(typ string) bool {
+	switch BaseType(typ) {
+	case TypeWrfout, TypeAuxhist, TypeRestart:
+		return true
+	}
+	return false
+}
+
+// BaseType returns typ without the trailing
+// stream number, e.g. `auxhist` for `auxhist23`.
+func BaseType(typ string) string {
+	return strings.TrimRight(typ, "0123456789")
+}
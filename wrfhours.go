@@ -2,16 +2,20 @@ package wrfhours
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
-const filesPrefix = "Timing for Writing "
-
 // FileInfo contains information about a single file
 // created by WRF.
 type FileInfo struct {
@@ -25,12 +29,40 @@ type FileInfo struct {
 	// is hour 0)
 	HourProgr int
 	Filename  string
-	Err       error
+	// Elapsed is the time WRF spent writing the file.
+	// It's zero when the timing line doesn't report it.
+	Elapsed time.Duration `json:",omitempty"`
+	// ElapsedSeconds is Elapsed as reported in the log.
+	ElapsedSeconds float64 `json:",omitempty"`
+	Err            error
+	// Discovered is the wall clock time at which the parser
+	// found the file in the log. It's recorded only when
+	// enabled with Parser.SetRecordDiscovery.
+	Discovered *time.Time `json:",omitempty"`
+	// RunIndex is the progressive number of the
+	// run the file belongs to (0 based), when
+	// parsing multiple runs with Parser.SetMultiRun.
+	RunIndex int `json:",omitempty"`
+	// SourceLine is the log line the file was
+	// parsed from. It's kept only when enabled
+	// with Parser.SetKeepSourceLine.
+	SourceLine string `json:",omitempty"`
+	// Done is set only on the record emitted to
+	// signal the end of the stream, when enabled
+	// with Parser.SetEmitDone.
+	Done bool `json:",omitempty"`
+	// Meta contains the metadata set with Parser.SetMeta,
+	// e.g. the ID of the run. The map is shared by all
+	// the files emitted by a parser, and must not be modified.
+	Meta map[string]string `json:",omitempty"`
+	// relPath is the path of the file computed by the
+	// mapper set with Parser.SetPathMapper, if any.
+	relPath string
 }
 
 // IsEmpty ...
 func (f FileInfo) IsEmpty() bool {
-	return f.Type == "" && f.Err == nil
+	return f.Type == "" && f.Err == nil && !f.Done
 }
 
 // IsError ...
@@ -38,10 +70,127 @@ func (f FileInfo) IsError() bool {
 	return f.Type == "" && f.Err != nil
 }
 
+// String returns a compact representation of the file,
+// e.g. `wrfout d03 @2021-08-04T01:00:00Z (h=1)`.
+func (f FileInfo) String() string {
+	if f.IsError() {
+		return fmt.Sprintf("error: %s", f.Err)
+	}
+	if f.Done {
+		return "done"
+	}
+	return fmt.Sprintf("%s d%02d @%s (h=%d)", f.Type, f.Domain, f.Instant.Format(time.RFC3339), f.HourProgr)
+}
+
+// Equal returns whether f and other describe the same
+// file. All fields are compared except Err; Instant and
+// Discovered are compared with time.Time.Equal.
+func (f FileInfo) Equal(other FileInfo) bool {
+	return f.Type == other.Type &&
+		f.Domain == other.Domain &&
+		f.Instant.Equal(other.Instant) &&
+		f.HourProgr == other.HourProgr &&
+		f.Filename == other.Filename &&
+		f.Elapsed == other.Elapsed &&
+		f.ElapsedSeconds == other.ElapsedSeconds &&
+		equalTimes(f.Discovered, other.Discovered) &&
+		f.RunIndex == other.RunIndex &&
+		f.SourceLine == other.SourceLine &&
+		f.Done == other.Done &&
+		equalMeta(f.Meta, other.Meta) &&
+		f.relPath == other.relPath
+}
+
+// equalTimes reports whether a and b are both
+// nil, or point to the same instant.
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// equalMeta reports whether a and b
+// contain the same metadata.
+func equalMeta(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOptions contains the settings that
+// affect how the log lines are parsed. They are
+// copied by Parse before starting to read, so
+// changes made while parsing have no effect.
+type parseOptions struct {
+	recordDiscovery  bool
+	skipFirstLine    bool
+	isRestart        func(filename string) bool
+	verifyDomain     bool
+	stripANSI        bool
+	classifier       Classifier
+	maxFiles         int
+	startPrefix      string
+	filePrefix       string
+	multiRun         bool
+	keepSourceLine   bool
+	emitDone         bool
+	emitStepTiming   bool
+	dedup            bool
+	hourRounding     HourRounding
+	tee              io.Writer
+	readRetries      int
+	readBackoff      time.Duration
+	start            *time.Time
+	overrideStart    bool
+	bufferEarlyFiles bool
+	pathMapper       func(FileInfo) string
+	successSuffixes  []string
+	successLines     []string
+	meta             map[string]string
+	metadataOnly     bool
+	onStart          func(time.Time)
+	domainMapper     func(int) int
+	joinWrapped      bool
+	detectAbort      bool
+	beatInterval     time.Duration
+	heartbeat        func()
+}
+
+// lineClassifier returns the classifier set with
+// SetClassifier, or the default one with the
+// prefixes and success lines set.
+func (opts parseOptions) lineClassifier() Classifier {
+	if opts.classifier != nil {
+		return opts.classifier
+	}
+	return defaultClassifier{
+		startPrefix:     opts.startPrefix,
+		filePrefix:      opts.filePrefix,
+		successSuffixes: opts.successSuffixes,
+		successLines:    opts.successLines,
+	}
+}
+
+// ansiEscapes matches ANSI escape sequences, e.g. color codes.
+var ansiEscapes = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// isRestartFile is the default restart matcher.
+func isRestartFile(filename string) bool {
+	return filename == "restart"
+}
+
 type execHandler struct {
-	fn           func(info FileInfo) error
-	typeFilter   string
-	domainFilter int
+	fn func(info FileInfo) error
+	// match reports whether fn must be called for info,
+	// comparing base types when groupByBaseType is set.
+	match func(info FileInfo, groupByBaseType bool) bool
 }
 
 // Parser contains the results of a
@@ -59,32 +208,123 @@ type execHandler struct {
 // Both channel are closed by the Parse call.
 // Parser ...
 type Parser struct {
-	currline string
-	Start    *time.Time
-	Files    chan FileInfo
-	files    chan FileInfo
-	onClose  func() error
-	lock     sync.Mutex
-	handlers []execHandler
+	currline  string
+	Start     *time.Time
+	Files     chan FileInfo
+	files     chan FileInfo
+	onClose   func() error
+	lock      sync.Mutex
+	handlers  []execHandler
+	opts      parseOptions
+	cfg       parseOptions
+	done      chan struct{}
+	stopOnce  sync.Once
+	completed bool
+	emitted   int
+	// finished is closed when no more files
+	// will be emitted on Files.
+	finished   chan struct{}
+	clock      Clock
+	lastFile   time.Time
+	forwarding bool
+	// started reports whether the start line
+	// of current run has been parsed.
+	started  bool
+	runIndex int
+	steps    chan StepTiming
+	// stepsOnce closes steps once.
+	stepsOnce sync.Once
+	// startOverridable reports whether the start set
+	// with SetStart can be overridden by a start line.
+	startOverridable bool
+	// lineNum is the number of the current line.
+	lineNum int
+	// earlyFiles are the timing lines found before
+	// the start line, when buffered.
+	earlyFiles []earlyFile
+	// lastFilename is the name of the last file
+	// parsed, used to suppress duplicates.
+	lastFilename string
+	// lastInstant is the instant of the last
+	// file parsed in metadata only mode.
+	lastInstant time.Time
+	// groupByBaseType reports whether OnFileDo
+	// filters compare the base type of files.
+	groupByBaseType bool
+	// successLine is the line that
+	// signaled the run completed.
+	successLine string
+	// groupByDomain reports whether Collect
+	// groups the files by domain.
+	groupByDomain bool
+	// progress signals the timeout goroutine that
+	// a file has been parsed without being emitted.
+	progress chan struct{}
 }
 
+var errStopped = errors.New("parser stopped")
+
+// errCompleted is used internally to signal
+// the success line has been found.
+var errCompleted = errors.New("completed")
+
+// errMaxFiles is used internally to signal the
+// maximum number of files has been emitted.
+var errMaxFiles = errors.New("max files emitted")
+
+// ErrStopParsing can be returned by an OnFileDo
+// handler to stop Execute without reporting
+// an error.
+var ErrStopParsing = errors.New("stop parsing")
+
 // NewParser ...
 func NewParser(timeout time.Duration) *Parser {
+	return NewParserWithClock(timeout, realClock{})
+}
 
-	files := make(chan FileInfo)
-	Files := make(chan FileInfo)
-
-	parser := Parser{
-		Files: Files,
-		files: files,
-	}
+// NewParserWithClock works like NewParser, but uses
+// clock to measure time, so that tests can control
+// when the timeout expires.
+func NewParserWithClock(timeout time.Duration, clock Clock) *Parser {
+	parser := newParser(clock)
+	parser.Files = make(chan FileInfo)
+	parser.forwarding = true
 
 	go parser.forwardFilesWithTimeout(timeout)
 
-	return &parser
+	return parser
+}
+
+// NewParserNoTimeout creates a Parser without timeout.
+// Files are emitted on Files directly by the parsing
+// goroutine, without starting a goroutine to check
+// the timeout.
+func NewParserNoTimeout() *Parser {
+	parser := newParser(realClock{})
+	parser.Files = parser.files
+	return parser
+}
+
+func newParser(clock Clock) *Parser {
+	return &Parser{
+		files:    make(chan FileInfo),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		steps:    make(chan StepTiming),
+		progress: make(chan struct{}),
+		clock:    clock,
+		lastFile: clock.Now(),
+		opts: parseOptions{
+			isRestart:       isRestartFile,
+			startPrefix:     "d01 ",
+			filePrefix:      filesPrefix,
+			successSuffixes: []string{successSuffix},
+		},
+	}
 }
 
 func (parser *Parser) forwardFilesWithTimeout(timeout time.Duration) {
+	defer close(parser.finished)
 	defer close(parser.Files)
 	actualTimeout := 5 * time.Minute
 	for {
@@ -96,21 +336,39 @@ func (parser *Parser) forwardFilesWithTimeout(timeout time.Duration) {
 				return
 			}
 			// fmt.Println("inch recevied ", f)
-			parser.Files <- f
+			select {
+			case parser.Files <- f:
+			case <-parser.done:
+				return
+			}
 			// fmt.Println("outch sent ", f)
 
 			if f.Err != nil {
 				// fmt.Printlnln("return outch bacause err ")
 				return
 			}
-		case <-time.After(actualTimeout):
-			parser.Files <- FileInfo{Err: fmt.Errorf("Timeout expired: no new files created for more than %s", timeout)}
+		case <-parser.progress:
+			actualTimeout = timeout
+		case <-parser.done:
+			return
+		case <-parser.clock.After(actualTimeout):
+			// the consumer may have stopped reading
+			// files, and called Stop meanwhile.
+			select {
+			case parser.Files <- FileInfo{Err: &TimeoutError{Timeout: timeout}}:
+			case <-parser.done:
+			}
 			return
 		}
 	}
 }
 
-func (parser *Parser) runOnClose(err error) {
+// Finish ends the stream of files, as Parse does at the
+// end of the log: it runs the hook set with SetOnClose, then
+// emits err, or the hook error, if any, or else closes the
+// stream. It's meant for code emitting files with EmitFile
+// instead of Parse.
+func (parser *Parser) Finish(err error) {
 	parser.lock.Lock()
 	onClose := parser.onClose
 	parser.lock.Unlock()
@@ -126,94 +384,528 @@ func (parser *Parser) runOnClose(err error) {
 		return
 	}
 
+	if parser.cfg.emitDone {
+		parser.send(FileInfo{Done: true})
+	}
 	parser.Close()
 }
 
 // Parse ...
 func (parser *Parser) Parse(r io.Reader) {
+	parser.configure()
+
+	if parser.cfg.readRetries > 0 {
+		r = &retryReader{
+			r:       r,
+			retries: parser.cfg.readRetries,
+			backoff: parser.cfg.readBackoff,
+			clock:   parser.clock,
+			done:    parser.done,
+		}
+	}
+
+	parser.parseLines(bufio.NewScanner(r))
+}
+
+// ParseLines works like Parse, but reads the lines
+// of the log from a channel, e.g. when they are delivered
+// already split by a log shipper. Lines must not contain
+// the trailing newline. The end of the log is signaled by
+// closing lines. SetReadRetry has no effect on ParseLines.
+func (parser *Parser) ParseLines(lines <-chan string) {
+	parser.configure()
+	parser.parseLines(&chanScanner{lines: lines, done: parser.done})
+}
+
+// ParseFrom works like Parse, but parses the log of given
+// size read from ra starting from approxOffset, to skip the
+// files written before it. The partial line at approxOffset
+// is discarded. Since the start instant is needed to compute
+// the HourProgr of files, the start line, as recognized with
+// the settings of the parser, e.g. SetStartDomainPrefix, is
+// searched from the beginning of the log and parsed before
+// the rest of it. A start line crossing approxOffset is read
+// whole.
+func (parser *Parser) ParseFrom(ra io.ReaderAt, size int64, approxOffset int64) {
+	if approxOffset <= 0 {
+		parser.Parse(io.NewSectionReader(ra, 0, size))
+		return
+	}
+
+	parser.lock.Lock()
+	classifier := parser.opts.lineClassifier()
+	parser.lock.Unlock()
+
+	var head io.Reader = strings.NewReader("")
+	lines := bufio.NewReader(io.NewSectionReader(ra, 0, size))
+	for offset := int64(0); offset < approxOffset; {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			// only lines ending with a newline are complete.
+			break
+		}
+		if kind, _ := classifier.Classify(strings.TrimRight(line, "\r\n"), false); kind == StartLine {
+			head = strings.NewReader(line)
+			break
+		}
+		offset += int64(len(line))
+	}
+
+	tail := bufio.NewReader(io.NewSectionReader(ra, approxOffset, size-approxOffset))
+	prev := make([]byte, 1)
+	if _, err := ra.ReadAt(prev, approxOffset-1); err != nil || prev[0] != '\n' {
+		tail.ReadString('\n')
+	}
+
+	parser.Parse(io.MultiReader(head, tail))
+}
+
+// lineScanner is the source of the lines
+// of the log. It's implemented by bufio.Scanner.
+type lineScanner interface {
+	Scan() bool
+	Text() string
+	Err() error
+}
+
+// chanScanner is a lineScanner reading lines
+// from a channel, until it's closed or done is.
+type chanScanner struct {
+	lines <-chan string
+	done  chan struct{}
+	line  string
+}
+
+func (s *chanScanner) Scan() bool {
+	select {
+	case line, ok := <-s.lines:
+		s.line = line
+		return ok
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *chanScanner) Text() string {
+	return s.line
+}
+
+func (s *chanScanner) Err() error {
+	return nil
+}
+
+// maxContinuations is the maximum number of
+// lines joined to a wrapped timing line.
+const maxContinuations = 3
+
+// wrappedScanner is a lineScanner joining the timing
+// lines starting with prefix with their continuation
+// lines, until they end with `elapsed seconds`, or
+// the next line is itself a record recognized by isRecord.
+type wrappedScanner struct {
+	lineScanner
+	prefix   string
+	isRecord func(line string) bool
+	// onContinuation is called for each line joined.
+	onContinuation func()
+	line           string
+	// next is a line read ahead and not joined,
+	// to return on the next Scan.
+	next    string
+	hasNext bool
+}
+
+func (s *wrappedScanner) Scan() bool {
+	if !s.scanNext() {
+		return false
+	}
+	s.line = s.next
+	s.hasNext = false
+	if !strings.HasPrefix(s.line, s.prefix) {
+		return true
+	}
+	for i := 0; i < maxContinuations && !isCompleteTiming(s.line); i++ {
+		if !s.scanNext() || s.isRecord(s.next) {
+			break
+		}
+		s.hasNext = false
+		s.onContinuation()
+		s.line += s.next
+	}
+	return true
+}
+
+// scanNext reads the next line in next,
+// unless it was already read ahead.
+func (s *wrappedScanner) scanNext() bool {
+	if s.hasNext {
+		return true
+	}
+	if !s.lineScanner.Scan() {
+		return false
+	}
+	s.next = s.lineScanner.Text()
+	s.hasNext = true
+	return true
+}
+
+func (s *wrappedScanner) Text() string {
+	return s.line
+}
+
+// isCompleteTiming reports whether line
+// ends as a complete timing line.
+func isCompleteTiming(line string) bool {
+	return strings.HasSuffix(strings.TrimRight(line, " \t\r"), "elapsed seconds")
+}
+
+// configure snapshots the options set
+// before parsing, and applies them.
+func (parser *Parser) configure() {
+	parser.lock.Lock()
+	parser.cfg = parser.opts
+	parser.lock.Unlock()
+
+	parser.cfg.classifier = parser.cfg.lineClassifier()
+
+	if parser.cfg.start != nil {
+		start := *parser.cfg.start
+		parser.lock.Lock()
+		parser.Start = &start
+		parser.lock.Unlock()
+		parser.started = true
+		parser.startOverridable = parser.cfg.overrideStart
+	}
+
+	if parser.cfg.heartbeat != nil {
+		go parser.beat(parser.cfg.beatInterval, parser.cfg.heartbeat)
+	}
+}
+
+// parseLines parse the lines read by scanner.
+func (parser *Parser) parseLines(scanner lineScanner) {
+	if parser.cfg.joinWrapped {
+		scanner = &wrappedScanner{
+			lineScanner: scanner,
+			prefix:      parser.cfg.filePrefix,
+			isRecord: func(line string) bool {
+				kind, _ := parser.cfg.classifier.Classify(line, false)
+				return kind != OtherLine
+			},
+			onContinuation: func() {
+				parser.lineNum++
+			},
+		}
+	}
+
+	if parser.cfg.skipFirstLine {
+		scanner.Scan()
+		parser.lineNum++
+	}
 
-	scanner := bufio.NewScanner(r)
 	var err error
 	for scanner.Scan() /**&& !hasDone*/ {
+		parser.lineNum++
 		parser.currline = scanner.Text()
+		if parser.cfg.stripANSI {
+			parser.currline = ansiEscapes.ReplaceAllString(parser.currline, "")
+		}
 		if err = parser.parseCurrLine(); err != nil {
-			if err.Error() == "completed" {
+			if err == errCompleted {
+				parser.lock.Lock()
+				parser.completed = true
+				parser.lock.Unlock()
+				if parser.cfg.multiRun {
+					// wait for the start line of next run
+					parser.started = false
+					parser.runIndex++
+					err = nil
+					continue
+				}
 				//fmt.Println("RUNONCLOSE")
-				parser.runOnClose(nil)
+				parser.Finish(nil)
 				//fmt.Println("RUNONCLOSE DONE")
 				return
 			}
+			if err == errMaxFiles {
+				parser.Finish(nil)
+				return
+			}
 			break
 		}
 	}
 
 	if e := scanner.Err(); e != nil && err == nil {
 		err = e
-		return
 	}
-	if err == nil {
-		err = fmt.Errorf("input stream completed without success log line")
+	if err == nil && !(parser.cfg.multiRun && parser.CompletedSuccessfully()) {
+		err = ErrIncomplete
 	}
 
-	parser.runOnClose(err)
+	parser.Finish(err)
 
 }
 
 func (parser *Parser) parseCurrLine() error {
 
-	if parser.isStartInstantLine() {
-		if err := parser.parseStartInstant(); err != nil {
+	kind, text := parser.cfg.classifier.Classify(parser.currline, parser.started && !parser.startOverridable)
+
+	switch kind {
+	case StartLine:
+		if err := parser.parseStartInstant(text); err != nil {
 			return err
 		}
-		return nil
+		return parser.parseEarlyFiles()
+	case FileLine:
+		if !parser.started && parser.cfg.bufferEarlyFiles {
+			parser.earlyFiles = append(parser.earlyFiles, earlyFile{text: text, line: parser.currline})
+			return nil
+		}
+		if parser.cfg.metadataOnly {
+			return parser.recordLastInstant(text)
+		}
+		return parser.parseFileLine(text)
+	case StepLine:
+		if !parser.cfg.emitStepTiming {
+			return nil
+		}
+		step, err := parseStepTiming(text)
+		if err != nil {
+			return &FormatError{Kind: "timing", Line: parser.currline, Err: err}
+		}
+		select {
+		case parser.steps <- step:
+		case <-parser.done:
+			return errStopped
+		}
+	case AbortLine:
+		if !parser.cfg.detectAbort {
+			return nil
+		}
+		return &AbortError{Line: parser.currline}
+	case SuccessLine:
+		parser.lock.Lock()
+		parser.successLine = parser.currline
+		parser.lock.Unlock()
+		return errCompleted
 	}
 
-	if parser.isFileInfoLine() {
-		info := parser.parseFileInfo()
-		if info.Err != nil {
-			return info.Err
+	return nil
+
+}
+
+// parseFileLine parse a line identified as a 'file writing'
+// log line, emitting the file. text is the part of the line
+// following the timing prefix.
+func (parser *Parser) parseFileLine(text string) error {
+	info := parser.parseFileInfo(text)
+	if info.Err != nil {
+		return info.Err
+	}
+
+	if info.Type != TypeRestart {
+		if parser.cfg.dedup && info.Filename == parser.lastFilename {
+			return nil
 		}
+		parser.lastFilename = info.Filename
+		if parser.cfg.recordDiscovery {
+			now := parser.clock.Now()
+			info.Discovered = &now
+		}
+		if parser.cfg.keepSourceLine {
+			info.SourceLine = parser.currline
+		}
+		info.Meta = parser.cfg.meta
+		if parser.cfg.pathMapper != nil {
+			info.relPath = parser.cfg.pathMapper(info)
+		}
+		if parser.cfg.tee != nil {
+			if err := writeNDJSON(parser.cfg.tee, info); err != nil {
+				return err
+			}
+		}
+		if !parser.send(info) {
+			return errStopped
+		}
+		parser.emitted++
+		if parser.cfg.maxFiles > 0 && parser.emitted >= parser.cfg.maxFiles {
+			return errMaxFiles
+		}
+	}
+	return nil
+}
 
-		if info.Type != "restart" {
-			parser.files <- info
+// earlyFile is a timing line found before the start line.
+type earlyFile struct {
+	text string
+	line string
+}
+
+// parseEarlyFiles parse the timing lines found
+// before the start line, when buffered.
+func (parser *Parser) parseEarlyFiles() error {
+	currline := parser.currline
+	defer func() { parser.currline = currline }()
+
+	for len(parser.earlyFiles) > 0 {
+		early := parser.earlyFiles[0]
+		parser.earlyFiles = parser.earlyFiles[1:]
+		parser.currline = early.line
+		parse := parser.parseFileLine
+		if parser.cfg.metadataOnly {
+			parse = parser.recordLastInstant
+		}
+		if err := parse(early.text); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if parser.isSuccessLine() {
-		return fmt.Errorf("completed")
+// send emits info on the internal channel.
+// It returns false without emitting when the parser
+// has been stopped.
+func (parser *Parser) send(info FileInfo) bool {
+	select {
+	case parser.files <- info:
+		parser.lock.Lock()
+		parser.lastFile = parser.clock.Now()
+		parser.lock.Unlock()
+		return true
+	case <-parser.done:
+		return false
 	}
+}
 
+// signalProgress restarts the timeout for a file parsed
+// without being emitted, as in metadata only mode. It
+// returns errStopped when the parser has been stopped.
+func (parser *Parser) signalProgress() error {
+	if !parser.forwarding {
+		return nil
+	}
+	select {
+	case parser.progress <- struct{}{}:
+		parser.lock.Lock()
+		parser.lastFile = parser.clock.Now()
+		parser.lock.Unlock()
+	case <-parser.finished:
+		// the timeout already expired.
+	case <-parser.done:
+		return errStopped
+	}
 	return nil
+}
 
+// Stop signals the parser that the caller
+// will not read from Files anymore, so that
+// the parsing goroutine can exit instead of
+// blocking on the next file. It's safe to call
+// Stop more than once.
+func (parser *Parser) Stop() {
+	parser.stopOnce.Do(func() {
+		close(parser.done)
+	})
 }
 
-// EmitFile ...
-func (parser *Parser) EmitFile(info FileInfo) {
-	parser.files <- info
+// Stopped returns a channel that's closed when Stop is
+// called, e.g. to interrupt the waits of producers feeding
+// the parser with EmitFile.
+func (parser *Parser) Stopped() <-chan struct{} {
+	return parser.done
+}
+
+// Drain discards the files remaining in Files, reading
+// them in a new goroutine until the channel is closed. It can
+// be used by callers abandoning the parse, e.g. after WaitFor,
+// to let the parser complete. Unlike Stop, the rest of the
+// log is parsed, so the OnClose hook is run as usual.
+func (parser *Parser) Drain() {
+	go func() {
+		for range parser.Files {
+		}
+	}()
+}
+
+// EmitFile emits info on Files, e.g. for producers other
+// than Parse. It returns false without emitting info when
+// the parser has been stopped.
+func (parser *Parser) EmitFile(info FileInfo) bool {
+	return parser.send(info)
 }
 
 // Close ...
 func (parser *Parser) Close() {
+	parser.stepsOnce.Do(func() { close(parser.steps) })
 	close(parser.files)
+	if !parser.forwarding {
+		close(parser.finished)
+	}
+}
+
+// ParseTimingLine parse a single timing line, e.g.
+// `Timing for Writing wrfout_d01_2021-08-04_01:00:00 for domain        1:    0.47585 elapsed seconds`,
+// of a run started at start, as a Parser with the default settings
+// would do. Restart files are returned with Type TypeRestart only.
+func ParseTimingLine(line string, start time.Time) (FileInfo, error) {
+	if !strings.HasPrefix(line, filesPrefix) {
+		return FileInfo{}, &FormatError{
+			Kind: "timing",
+			Line: line,
+			Err:  fmt.Errorf("line must start with `%s`", filesPrefix),
+		}
+	}
+
+	parser := &Parser{
+		Start:    &start,
+		started:  true,
+		currline: line,
+		cfg:      parseOptions{isRestart: isRestartFile},
+	}
+	info := parser.parseFileInfo(strings.TrimPrefix(line, filesPrefix))
+	if info.Err != nil {
+		return FileInfo{}, info.Err
+	}
+	return info, nil
+}
+
+// ParseStartLine parse the first instant of the simulation
+// from a start line, e.g.
+// `d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated`,
+// as a Parser with the default settings would do.
+// Leading whitespace is ignored.
+func ParseStartLine(line string) (time.Time, error) {
+	if ClassifyLine(line, false) != StartLine {
+		return time.Time{}, &FormatError{
+			Kind: "start instant",
+			Line: line,
+			Err:  fmt.Errorf("line must start with `%s`", standardClassifier().startPrefix),
+		}
+	}
+
+	parser := &Parser{currline: line}
+	if err := parser.parseStartInstant(strings.TrimLeft(line, " \t")); err != nil {
+		return time.Time{}, err
+	}
+	return *parser.Start, nil
 }
 
 // parse a single line already identified as a 'file writing' log line.
-func (parser *Parser) parseFileInfo() (info FileInfo) {
-	if parser.Start == nil {
-		return FileInfo{Err: fmt.Errorf("Start line not found yet")}
+// fname is the part of the line following the timing prefix.
+func (parser *Parser) parseFileInfo(fname string) (info FileInfo) {
+	if !parser.started {
+		return FileInfo{Err: fmt.Errorf("%w: timing line %d `%s` precedes it", ErrStartNotFound, parser.lineNum, parser.currline)}
 	}
 
 	defer func() {
 		if info.Err != nil {
-			info.Err = fmt.Errorf("Wrong format for timing line `%s`: %w", parser.currline, info.Err)
+			info.Err = &FormatError{Kind: "timing", Line: parser.currline, Err: info.Err}
 		}
 	}()
 
 	info = FileInfo{}
 
 	// line contains: Timing for Writing auxhist23_d03_2021-08-04_01:00:00 for domain        3:   10.02259 elapsed seconds
-	fname := strings.TrimPrefix(parser.currline, filesPrefix)
-
 	// fname contains: auxhist23_d03_2021-08-04_01:00:00 for domain        3:   10.02259 elapsed seconds
 	fnameParts := strings.Split(fname, " for domain")
 	if len(fnameParts) != 2 {
@@ -226,8 +918,8 @@ func (parser *Parser) parseFileInfo() (info FileInfo) {
 
 	// skip WRF restart files with this form:
 	// `Timing for Writing restart for domain        1:    1.33332 elapsed seconds`
-	if info.Filename == "restart" {
-		return FileInfo{Type: "restart"}
+	if parser.cfg.isRestart(info.Filename) {
+		return FileInfo{Type: TypeRestart}
 	}
 
 	if info.Filename == "filter output" {
@@ -251,65 +943,156 @@ func (parser *Parser) parseFileInfo() (info FileInfo) {
 		return FileInfo{Err: fmt.Errorf("invalid domain: %w", err)}
 	}
 
-	// filenameParts[2]+filenameParts[3] == 2021-08-0401:00:00
-	if instant, err := time.Parse("2006-01-0215:04:05", filenameParts[2]+filenameParts[3]); err == nil {
-		info.Instant = instant
-	} else {
-		// try without seconds
+	if parser.cfg.verifyDomain {
+		domain, err := parseTrailingDomain(fname)
+		if err != nil {
+			return FileInfo{Err: err}
+		}
+		if domain != info.Domain {
+			return FileInfo{Err: fmt.Errorf("filename domain %d differs from trailing domain %d", info.Domain, domain)}
+		}
+	}
 
-		if instant, e := time.Parse("2006-01-0215:04", filenameParts[2]+filenameParts[3]); e == nil {
-			info.Instant = instant
-		} else {
-			// try without seconds
+	if parser.cfg.domainMapper != nil {
+		info.Domain = parser.cfg.domainMapper(info.Domain)
+	}
 
-			return FileInfo{Err: fmt.Errorf("invalid time instant: %w", err)}
+	// filenameParts[2]+filenameParts[3] == 2021-08-0401:00:00
+	instant, err := parseInstant(filenameParts[2] + filenameParts[3])
+	if err != nil {
+		return FileInfo{Err: fmt.Errorf("invalid time instant: %w", err)}
+	}
+	info.Instant = instant
+
+	// fnameParts[1] contains:         3:   10.02259 elapsed seconds
+	// The elapsed seconds are informative only, so when they are
+	// missing or malformed the file is emitted anyway, without them.
+	if timing := strings.SplitN(fnameParts[1], ":", 2); len(timing) == 2 {
+		if seconds, err := parseElapsed(timing[1]); err == nil {
+			info.ElapsedSeconds = seconds
+			info.Elapsed = secondsToDuration(seconds)
 		}
-
 	}
 
-	info.HourProgr = int(info.Instant.Sub(*parser.Start).Hours())
+	info.HourProgr = parser.cfg.hourRounding.apply(info.Instant.Sub(*parser.Start).Hours())
+	info.RunIndex = parser.runIndex
 
 	// fmt.Printlnln(info)
 	return info
 }
 
-func (parser *Parser) parseStartInstant() error {
+// parseElapsed parse the elapsed seconds of a timing line, e.g.
+// `   10.02259 elapsed seconds`. Decimal commas, as written by
+// WRF builds using some european locales, are accepted.
+func parseElapsed(value string) (float64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("elapsed seconds expected after `for domain N:`")
+	}
+	seconds, err := strconv.ParseFloat(strings.Replace(fields[0], ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid elapsed seconds: %w", err)
+	}
+	return seconds, nil
+}
+
+// secondsToDuration converts seconds to a Duration,
+// rounding to the nearest nanosecond.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
+
+// HourRounding is the method used to compute the
+// HourProgr of files lying between two hours.
+type HourRounding int
+
+const (
+	// HourTruncate truncates to the previous hour,
+	// e.g. a file at +0:59:30 has HourProgr 0.
+	HourTruncate HourRounding = iota
+	// HourRound rounds to the nearest hour,
+	// e.g. a file at +0:59:30 has HourProgr 1.
+	HourRound
+	// HourCeil rounds to the next hour,
+	// e.g. a file at +0:20:00 has HourProgr 1.
+	HourCeil
+)
+
+func (rounding HourRounding) apply(hours float64) int {
+	switch rounding {
+	case HourRound:
+		return int(math.Round(hours))
+	case HourCeil:
+		return int(math.Ceil(hours))
+	}
+	return int(hours)
+}
+
+// instantLayouts lists the layouts accepted for
+// the instant part of WRF filenames, in the order
+// they are tried. Fractional seconds, e.g. `01:00:00.500`,
+// need no dedicated layout, since time.Parse accepts
+// them after the seconds field.
+var instantLayouts = []string{
+	"2006-01-0215:04:05",
+	// without seconds
+	"2006-01-0215:04",
+	// colons replaced by dashes, as produced by
+	// NFS-safe filename settings
+	"2006-01-0215-04-05",
+}
+
+// parseInstant parse the instant part of a WRF filename
+// trying all instantLayouts. When none matches, it returns
+// the error of the first one.
+func parseInstant(value string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range instantLayouts {
+		instant, err := time.Parse(layout, value)
+		if err == nil {
+			return instant, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
+func (parser *Parser) parseStartInstant(line string) error {
 	// first line starting with d01 contains first instant of simulation
 	// The line appear as:
 	// d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated
-	lineParts := strings.SplitN(parser.currline, " ", 3)
+	lineParts := strings.SplitN(line, " ", 3)
 	if len(lineParts) != 3 {
-		return fmt.Errorf("Wrong format for start instant line `%s`: line must contains at leas 3 space separated parts. e.g. `d01 2021-08-04_00:00:00 something`", parser.currline)
-
+		return &FormatError{
+			Kind: "start instant",
+			Line: parser.currline,
+			Err:  errors.New("line must contains at leas 3 space separated parts. e.g. `d01 2021-08-04_00:00:00 something`"),
+		}
 	}
 	if instant, err := time.Parse("2006-01-02_15:04:05", lineParts[1]); err == nil {
+		parser.lock.Lock()
 		parser.Start = &instant
+		parser.completed = false
+		parser.successLine = ""
+		parser.lock.Unlock()
+		parser.started = true
+		parser.startOverridable = false
+		if parser.cfg.onStart != nil {
+			parser.cfg.onStart(instant)
+		}
 	} else {
-		return fmt.Errorf("Wrong format for start instant line `%s`: %w", parser.currline, err)
+		return &FormatError{Kind: "start instant", Line: parser.currline, Err: err}
 	}
 
 	return nil
 }
 
-func (parser *Parser) isSuccessLine() bool {
-
-	res := strings.HasSuffix(parser.currline, "SUCCESS COMPLETE WRF")
-	//fmt.Printf("is success %s: %t\n", parser.currline, res)
-	return res
-}
-
-func (parser *Parser) isStartInstantLine() bool {
-	return strings.HasPrefix(parser.currline, "d01 ") && parser.Start == nil
-}
-
-func (parser *Parser) isFileInfoLine() bool {
-	return strings.HasPrefix(parser.currline, filesPrefix)
-}
-
 // EmitError ...
 func (parser *Parser) EmitError(err error) {
 	// fmt.Printlnln("write err")
-	parser.files <- FileInfo{Err: err}
+	parser.send(FileInfo{Err: err})
 	// fmt.Printlnln("err written")
 	parser.Close()
 	// fmt.Printlnln("files closed")
@@ -322,35 +1105,539 @@ func (parser *Parser) SetOnClose(fn func() error) {
 	parser.onClose = fn
 }
 
+// SetOnStart sets fn to be called with the start instant
+// as soon as the start line is parsed, before any file is
+// emitted, e.g. to show the base time of the run. It's called
+// once for each run, and not called for a start set with
+// SetStart and not overridden. It must be called before Parse.
+func (parser *Parser) SetOnStart(fn func(start time.Time)) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.onStart = fn
+}
+
+// SetHeartbeat sets fn to be called every interval while
+// the parser is waiting for the next file, e.g. to show the
+// parse is still alive during long idle periods. It doesn't
+// affect the timeout. It must be called before Parse.
+func (parser *Parser) SetHeartbeat(interval time.Duration, fn func()) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.beatInterval = interval
+	parser.opts.heartbeat = fn
+}
+
+// beat calls fn every interval of the parser clock in which
+// no file was emitted, until the parse ends.
+func (parser *Parser) beat(interval time.Duration, fn func()) {
+	for {
+		select {
+		case <-parser.clock.After(interval):
+			parser.lock.Lock()
+			idle := parser.clock.Now().Sub(parser.lastFile)
+			parser.lock.Unlock()
+			if idle >= interval {
+				fn()
+			}
+		case <-parser.finished:
+			return
+		case <-parser.done:
+			return
+		}
+	}
+}
+
+// SetRecordDiscovery enables or disables recording
+// in each emitted FileInfo the time at which the
+// file was found in the log. It must be called
+// before Parse.
+func (parser *Parser) SetRecordDiscovery(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.recordDiscovery = enabled
+}
+
+// SetSkipFirstLine enables or disables discarding
+// the first line read, before starting parsing.
+// Use it when the log is known to begin with
+// a partial line, e.g. when it's read from a
+// ring buffer. It must be called before Parse.
+func (parser *Parser) SetSkipFirstLine(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.skipFirstLine = enabled
+}
+
+// CompletedSuccessfully returns whether the
+// success line of WRF has been found in the log.
+func (parser *Parser) CompletedSuccessfully() bool {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	return parser.completed
+}
+
+// SuccessLine returns the line that signaled the run
+// completed successfully, e.g. to tell which one of the
+// success markers set with SetSuccessSuffix matched. It
+// returns an empty string until the run has completed.
+func (parser *Parser) SuccessLine() string {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	return parser.successLine
+}
+
+// SetRestartMatcher sets the function used to recognize
+// restart files, which are not emitted. By default only
+// files named `restart` are recognized. A nil matcher restores
+// the default. It must be called before Parse.
+func (parser *Parser) SetRestartMatcher(matcher func(filename string) bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	if matcher == nil {
+		matcher = isRestartFile
+	}
+	parser.opts.isRestart = matcher
+}
+
+// SetDomainMapper sets fn to convert the domain numbers
+// of WRF to the ones used downstream, e.g. to number as
+// 30 the domain 3. The Domain field of emitted files, and
+// so filters, use the converted number, while Filename is
+// left untouched. The default is to keep WRF numbers.
+// It must be called before Parse.
+func (parser *Parser) SetDomainMapper(fn func(domain int) int) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.domainMapper = fn
+}
+
+// SetVerifyDomain enables or disables checking that
+// the domain in the filename of timing lines agrees with
+// the one in the trailing `for domain N:` part. When enabled,
+// a mismatch is reported as a format error. It must be called
+// before Parse.
+func (parser *Parser) SetVerifyDomain(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.verifyDomain = enabled
+}
+
+// SetStripANSI enables or disables removing ANSI escape
+// sequences from each line before parsing it. Enable it
+// to parse logs captured through colorizing tools.
+// It must be called before Parse.
+func (parser *Parser) SetStripANSI(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.stripANSI = enabled
+}
+
+// SetJoinWrappedLines enables or disables joining timing lines
+// wrapped across multiple lines, e.g. by the terminal they were
+// captured from: a timing line not ending with `elapsed seconds`
+// is joined with the following lines, up to 3, until it does.
+// Joining stops early at a line recognized on its own, e.g.
+// another timing line, as for truncated lines.
+// It must be called before Parse.
+func (parser *Parser) SetJoinWrappedLines(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.joinWrapped = enabled
+}
+
+// SetClassifier sets the Classifier used to recognize
+// the kind of each log line, to support logs of WRF versions
+// that format them differently. A nil classifier restores
+// the default one. It must be called before Parse.
+func (parser *Parser) SetClassifier(classifier Classifier) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.classifier = classifier
+}
+
+// SetStartDomainPrefix sets the prefix of the line containing
+// the start instant of the simulation. It defaults to `d01 `,
+// and can be changed e.g. to `d02 ` for logs where domain 1
+// is not printed. It has no effect when a custom Classifier
+// is set. It must be called before Parse.
+func (parser *Parser) SetStartDomainPrefix(prefix string) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.startPrefix = prefix
+}
+
+// SetFilePrefix sets the prefix of the lines reporting the
+// writing of a file. It defaults to `Timing for Writing `,
+// and can be changed for patched WRF versions that phrase
+// it differently, e.g. `Timing for Writing of `. It has no
+// effect when a custom Classifier is set. It must be called
+// before Parse.
+func (parser *Parser) SetFilePrefix(prefix string) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.filePrefix = prefix
+}
+
+// SetHourRounding sets how the HourProgr of files is computed
+// from their distance from the start instant. It defaults to
+// HourTruncate. It must be called before Parse.
+func (parser *Parser) SetHourRounding(rounding HourRounding) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.hourRounding = rounding
+}
+
+// SetTee sets a writer on which each file is written as
+// a JSON line, in the same format of the json package,
+// before being emitted on Files. A write failure ends the
+// parse with an error. It must be called before Parse.
+func (parser *Parser) SetTee(w io.Writer) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.tee = w
+}
+
+// writeNDJSON writes info to w as a JSON line.
+func writeNDJSON(w io.Writer, info FileInfo) error {
+	buff, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(w, string(buff)); err != nil {
+		return fmt.Errorf("Tee failed: error while writing: %w", err)
+	}
+	return nil
+}
+
+// SetReadRetry makes the parser retry up to n consecutive
+// times, waiting backoff before each retry, reads failed
+// with a transient error: io.ErrUnexpectedEOF or errors
+// with a Temporary method returning true, e.g. some
+// net.Error. It must be called before Parse.
+func (parser *Parser) SetReadRetry(n int, backoff time.Duration) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.readRetries = n
+	parser.opts.readBackoff = backoff
+}
+
+// SetStart sets the start instant of the simulation, e.g.
+// when already known from the namelist, so that timing lines
+// are parsed even if the log has no start line. By default,
+// start lines found in the log are then ignored: see
+// SetStartOverride. It must be called before Parse.
+func (parser *Parser) SetStart(start time.Time) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.start = &start
+}
+
+// SetStartOverride makes the first start line found in
+// the log override the start instant set with SetStart.
+// It must be called before Parse.
+func (parser *Parser) SetStartOverride(override bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.overrideStart = override
+}
+
+// SetBufferEarlyFiles makes the parser keep the timing lines
+// found before the start line, and parse them once it's found,
+// instead of failing. It's disabled by default. It must be
+// called before Parse.
+func (parser *Parser) SetBufferEarlyFiles(buffer bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.bufferEarlyFiles = buffer
+}
+
+// SetPathMapper sets a function computing the path of
+// each file relative to the WRF run directory, returned
+// by FileInfo.RelPath, e.g. to follow conventions placing
+// files of each type in a subdirectory. The path isn't
+// preserved when files are marshalled to JSON.
+// It must be called before Parse.
+func (parser *Parser) SetPathMapper(mapper func(FileInfo) string) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.pathMapper = mapper
+}
+
+// SetDedup enables the suppression of files whose
+// timing line is repeated consecutively, as happens with
+// WRF builds printing it once per I/O quilting server.
+// It's disabled by default. It must be called before Parse.
+func (parser *Parser) SetDedup(dedup bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.dedup = dedup
+}
+
+// SetSuccessSuffix sets the suffixes of the line signaling the
+// simulation completed successfully, replacing the default
+// `SUCCESS COMPLETE WRF`: include it to keep recognizing the
+// WRF banner. It has no effect when a custom Classifier is set.
+// It must be called before Parse.
+func (parser *Parser) SetSuccessSuffix(suffixes ...string) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.successSuffixes = suffixes
+}
+
+// SetSuccessLine sets lines signaling the simulation completed
+// successfully when matched exactly, ignoring leading and trailing
+// spaces, in addition to the success suffixes, e.g. a `JOB DONE`
+// marker appended by a wrapper script. It has no effect when a
+// custom Classifier is set. It must be called before Parse.
+func (parser *Parser) SetSuccessLine(lines ...string) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.successLines = lines
+}
+
+// SetMaxFiles sets the maximum number of files to emit:
+// once n files have been emitted, the stream is closed
+// without errors. A value <= 0 means no limit, which is
+// the default. It must be called before Parse.
+func (parser *Parser) SetMaxFiles(n int) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.maxFiles = n
+}
+
+// SetMultiRun enables or disables parsing logs made of
+// multiple runs concatenated. When enabled, the parsing
+// continues after a success line, waiting for the start
+// line of the next run, and the RunIndex of files is
+// incremented for each run. The stream then ends without
+// errors if the last run completed successfully.
+// It must be called before Parse.
+func (parser *Parser) SetMultiRun(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.multiRun = enabled
+}
+
+// SetDetectAbort enables or disables failing the parse
+// with an *AbortError on the `FATAL CALLED` lines WRF
+// writes when it aborts. When disabled, these lines are
+// ignored, and the parse waits for the next files until
+// the timeout expires. It must be called before Parse.
+func (parser *Parser) SetDetectAbort(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.detectAbort = enabled
+}
+
+// SetKeepSourceLine enables or disables keeping in the
+// SourceLine field of each emitted FileInfo the log line
+// it was parsed from. It must be called before Parse.
+func (parser *Parser) SetKeepSourceLine(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.keepSourceLine = enabled
+}
+
+// SetMeta sets metadata to stamp in the Meta field of
+// every emitted FileInfo, e.g. a run ID or a node name
+// to let consumers correlate the files. meta is copied,
+// so later changes to it have no effect. It must be
+// called before Parse.
+func (parser *Parser) SetMeta(meta map[string]string) {
+	var copied map[string]string
+	if len(meta) > 0 {
+		copied = make(map[string]string, len(meta))
+		for k, v := range meta {
+			copied[k] = v
+		}
+	}
+
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.meta = copied
+}
+
+// SetEmitDone enables or disables emitting, when the
+// stream completes without errors, a last record with
+// Done set to true, before closing the Files channel.
+// It must be called before Parse.
+func (parser *Parser) SetEmitDone(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.opts.emitDone = enabled
+}
+
 // Collect ...
 func (parser *Parser) Collect() ([]FileInfo, error) {
+	actual, err := parser.CollectPartial()
+	if err != nil {
+		return nil, err
+	}
+	return actual, nil
+}
+
+// CollectPartial works like Collect, but when the parse
+// fails, e.g. because the timeout expired, it returns the
+// files emitted before the error together with the error.
+func (parser *Parser) CollectPartial() ([]FileInfo, error) {
 	actual := []FileInfo{}
 
+	var err error
 	for file := range parser.Files {
 		if file.Err != nil {
-			return nil, file.Err
+			err = file.Err
+			break
+		}
+		if file.Done {
+			continue
 		}
 		actual = append(actual, file)
 	}
 
-	return actual, nil
+	parser.lock.Lock()
+	groupByDomain := parser.groupByDomain
+	parser.lock.Unlock()
+	if groupByDomain {
+		sort.SliceStable(actual, func(i, j int) bool {
+			return actual[i].Domain < actual[j].Domain
+		})
+	}
+
+	return actual, err
+}
+
+// SetGroupByDomain enables or disables grouping the files
+// returned by Collect and CollectPartial by domain, in
+// ascending order, keeping the order in which the files of
+// each domain were emitted, e.g. to write per-domain manifests
+// in one pass. Since those methods keep all the files in memory
+// anyway, grouping costs no more memory; files read directly
+// from Files are not grouped. It must be called before Collect.
+func (parser *Parser) SetGroupByDomain(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.groupByDomain = enabled
+}
+
+// FilesInHours consumes the stream and returns the
+// files whose HourProgr is in the range [from, to).
+func (parser *Parser) FilesInHours(from, to int) ([]FileInfo, error) {
+	files := []FileInfo{}
+
+	for file := range parser.Files {
+		if file.Err != nil {
+			return nil, file.Err
+		}
+		if file.Done || file.HourProgr < from || file.HourProgr >= to {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// LastN consumes the stream and returns the last n files,
+// in the order they were emitted. Only the last n files
+// are kept in memory while reading the stream.
+func (parser *Parser) LastN(n int) ([]FileInfo, error) {
+	if n < 0 {
+		n = 0
+	}
+	ring := make([]FileInfo, n)
+	count := 0
+
+	for file := range parser.Files {
+		if file.Err != nil {
+			return nil, file.Err
+		}
+		if file.Done || n == 0 {
+			continue
+		}
+		ring[count%n] = file
+		count++
+	}
+
+	if count < n {
+		return ring[:count], nil
+	}
+	last := make([]FileInfo, 0, n)
+	last = append(last, ring[count%n:]...)
+	return append(last, ring[:count%n]...), nil
+}
+
+// HourList consumes the stream and returns the sorted list of
+// distinct HourProgr of the files with given type and domain.
+// As for OnFileDo, an empty type or a 0 domain match any file.
+func (parser *Parser) HourList(typ string, domain int) ([]int, error) {
+	filter := Filter{Type: typ, Domain: domain}
+	seen := map[int]bool{}
+	hours := []int{}
+
+	for file := range parser.Files {
+		if file.Err != nil {
+			return nil, file.Err
+		}
+		if file.Done || !filter.Matches(file) {
+			continue
+		}
+		if !seen[file.HourProgr] {
+			seen[file.HourProgr] = true
+			hours = append(hours, file.HourProgr)
+		}
+	}
+
+	sort.Ints(hours)
+	return hours, nil
+}
+
+// WaitFor consumes the stream until a file matching
+// filter is found, and returns it. It fails if the
+// stream ends, emits an error or ctx is done before.
+// Remaining files are left in the stream: the caller
+// should either keep reading them or call Stop.
+func (parser *Parser) WaitFor(ctx context.Context, filter Filter) (FileInfo, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return FileInfo{}, ctx.Err()
+		case file, ok := <-parser.Files:
+			if !ok {
+				return FileInfo{}, fmt.Errorf("stream completed without files matching %+v", filter)
+			}
+			if file.Err != nil {
+				return FileInfo{}, file.Err
+			}
+			if !file.Done && filter.Matches(file) {
+				return file, nil
+			}
+		}
+	}
 }
 
 // Execute ...
 func (parser *Parser) Execute() error {
+	parser.lock.Lock()
+	groupByBaseType := parser.groupByBaseType
+	parser.lock.Unlock()
+
 	for file := range parser.Files {
 		if file.Err != nil {
 			return file.Err
 		}
+		if file.Done {
+			continue
+		}
 		for _, handler := range parser.handlers {
-			if handler.domainFilter != 0 && handler.domainFilter != file.Domain {
-				continue
-			}
-			if handler.typeFilter != "" && handler.typeFilter != file.Type {
+			if !handler.match(file, groupByBaseType) {
 				continue
 			}
 
 			if err := handler.fn(file); err != nil {
+				parser.Stop()
+				if errors.Is(err, ErrStopParsing) {
+					return nil
+				}
 				return fmt.Errorf("OnFileDo handler failed: %s", err)
 			}
 		}
@@ -359,8 +1646,161 @@ func (parser *Parser) Execute() error {
 	return nil
 }
 
+// ExecuteParallel works like Execute, but calls the
+// handlers from a pool of workers goroutines, so that
+// handlers doing I/O can run concurrently. Handlers must
+// therefore be safe for concurrent use. On the first handler
+// failure no more files are dispatched, and ExecuteParallel
+// returns the error after all running handlers have finished.
+func (parser *Parser) ExecuteParallel(workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	parser.lock.Lock()
+	groupByBaseType := parser.groupByBaseType
+	parser.lock.Unlock()
+
+	type job struct {
+		handler execHandler
+		file    FileInfo
+	}
+
+	var (
+		lock     sync.Mutex
+		firstErr error
+		stopped  bool
+		wg       sync.WaitGroup
+	)
+	jobs := make(chan job)
+	failed := make(chan struct{})
+
+	stop := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		firstErr = err
+		close(failed)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := j.handler.fn(j.file); err != nil {
+					if errors.Is(err, ErrStopParsing) {
+						stop(nil)
+					} else {
+						stop(fmt.Errorf("OnFileDo handler failed: %s", err))
+					}
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for {
+		select {
+		case <-failed:
+			break dispatch
+		case file, ok := <-parser.Files:
+			if !ok {
+				break dispatch
+			}
+			if file.Err != nil {
+				stop(file.Err)
+				break dispatch
+			}
+			if file.Done {
+				continue
+			}
+			for _, handler := range parser.handlers {
+				if !handler.match(file, groupByBaseType) {
+					continue
+				}
+				select {
+				case jobs <- job{handler, file}:
+				case <-failed:
+					break dispatch
+				}
+			}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if stopped {
+		parser.Stop()
+	}
+	return firstErr
+}
+
 // OnFileDo ...
 func (parser *Parser) OnFileDo(typeFilter string, domainFilter int, fn func(info FileInfo) error) *Parser {
-	parser.handlers = append(parser.handlers, execHandler{fn, typeFilter, domainFilter})
+	match := func(info FileInfo, groupByBaseType bool) bool {
+		filter := Filter{Type: typeFilter, Domain: domainFilter, BaseType: groupByBaseType}
+		return filter.Matches(info)
+	}
+	parser.handlers = append(parser.handlers, execHandler{fn, match})
+	return parser
+}
+
+// SetGroupByBaseType enables or disables grouping the numbered
+// streams of a type in the filters of the handlers registered
+// with OnFileDo, e.g. to call a handler for files of type
+// `auxhist` for both `auxhist2` and `auxhist23` files, comparing
+// the type filter with the BaseType of files. It must be called
+// before Execute or ExecuteParallel.
+func (parser *Parser) SetGroupByBaseType(enabled bool) {
+	parser.lock.Lock()
+	defer parser.lock.Unlock()
+	parser.groupByBaseType = enabled
+}
+
+// OnFilePredicate registers fn to be called by Execute
+// for each file for which pred returns true. Use it
+// for conditions that can't be expressed by a Filter.
+func (parser *Parser) OnFilePredicate(pred func(info FileInfo) bool, fn func(info FileInfo) error) *Parser {
+	match := func(info FileInfo, _ bool) bool { return pred(info) }
+	parser.handlers = append(parser.handlers, execHandler{fn, match})
 	return parser
 }
+
+// OnHourComplete registers fn to be called by Execute
+// once expectedPerHour files with the same HourProgr have
+// been emitted, with that hour and its files in the order
+// they were emitted. Files of an hour arriving after fn was
+// called for it are ignored, and hours that never reach
+// expectedPerHour files are never reported.
+func (parser *Parser) OnHourComplete(expectedPerHour int, fn func(hour int, files []FileInfo) error) *Parser {
+	var lock sync.Mutex
+	pending := map[int][]FileInfo{}
+	completed := map[int]bool{}
+
+	return parser.OnFilePredicate(func(FileInfo) bool { return true }, func(info FileInfo) error {
+		lock.Lock()
+		hour := info.HourProgr
+		if completed[hour] {
+			lock.Unlock()
+			return nil
+		}
+		files := append(pending[hour], info)
+		if len(files) < expectedPerHour {
+			pending[hour] = files
+			lock.Unlock()
+			return nil
+		}
+		delete(pending, hour)
+		completed[hour] = true
+		lock.Unlock()
+
+		return fn(hour, files)
+	})
+}
@@ -0,0 +1,317 @@
+package wrfhours
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileInfo(t *testing.T) {
+	file := FileInfo{Type: "wrfout", Domain: 3, Filename: "wrfout_d03_2021-08-04_01:00:00"}
+
+	t.Run("Path", func(t *testing.T) {
+		expected := filepath.FromSlash("/data/run/wrfout_d03_2021-08-04_01:00:00")
+		assert.Equal(t, expected, file.Path(filepath.FromSlash("/data/run")))
+		assert.Equal(t, expected, file.Path(filepath.FromSlash("/data/run/")))
+		assert.Equal(t, "wrfout_d03_2021-08-04_01:00:00", file.Path(""))
+	})
+
+	t.Run("String", func(t *testing.T) {
+		file := file
+		file.Instant = time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC)
+		file.HourProgr = 1
+		assert.Equal(t, "wrfout d03 @2021-08-04T01:00:00Z (h=1)", file.String())
+		assert.Equal(t, "error: TEST", FileInfo{Err: errors.New("TEST")}.String())
+	})
+
+	t.Run("JSON omits the unset optional fields", func(t *testing.T) {
+		buff, err := json.Marshal(file)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"Type": "wrfout",
+			"Domain": 3,
+			"Instant": "0001-01-01T00:00:00Z",
+			"HourProgr": 0,
+			"Filename": "wrfout_d03_2021-08-04_01:00:00",
+			"Err": null
+		}`, string(buff))
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		now := time.Now()
+		file := file
+		file.Instant = time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC)
+		file.Discovered = &now
+
+		other := file
+		other.Err = errors.New("TEST")
+		assert.True(t, file.Equal(other))
+
+		other = file
+		other.Instant = file.Instant.In(time.FixedZone("CEST", 2*60*60))
+		discovered := now.In(time.FixedZone("CEST", 2*60*60))
+		other.Discovered = &discovered
+		assert.True(t, file.Equal(other))
+
+		later := now.Add(time.Second)
+		changes := map[string]func(f *FileInfo){
+			"Type":           func(f *FileInfo) { f.Type = "auxhist23" },
+			"Domain":         func(f *FileInfo) { f.Domain = 2 },
+			"Instant":        func(f *FileInfo) { f.Instant = f.Instant.Add(time.Hour) },
+			"HourProgr":      func(f *FileInfo) { f.HourProgr = 2 },
+			"Filename":       func(f *FileInfo) { f.Filename = "wrfout_d02_2021-08-04_01:00:00" },
+			"Elapsed":        func(f *FileInfo) { f.Elapsed = time.Second },
+			"ElapsedSeconds": func(f *FileInfo) { f.ElapsedSeconds = 1 },
+			"Discovered":     func(f *FileInfo) { f.Discovered = &later },
+			"nil Discovered": func(f *FileInfo) { f.Discovered = nil },
+			"RunIndex":       func(f *FileInfo) { f.RunIndex = 1 },
+			"SourceLine":     func(f *FileInfo) { f.SourceLine = "Timing for Writing" },
+			"Done":           func(f *FileInfo) { f.Done = true },
+			"Meta":           func(f *FileInfo) { f.Meta = map[string]string{"run": "1"} },
+		}
+		for field, change := range changes {
+			other := file
+			change(&other)
+			assert.False(t, file.Equal(other), field)
+			assert.False(t, other.Equal(file), field)
+		}
+
+		assert.False(t, FileInfo{Done: true}.Equal(FileInfo{}))
+		assert.True(t, FileInfo{Meta: map[string]string{}}.Equal(FileInfo{}))
+	})
+}
+
+func TestFilterString(t *testing.T) {
+	assert.Equal(t, "{Type:wrfout Domain:3 HourFrom:0 HourTo:0}", Filter{Type: "wrfout", Domain: 3}.String())
+	assert.Equal(t, "{Type:auxhist Domain:0 HourFrom:1 HourTo:5 BaseType:true}", Filter{Type: "auxhist", HourFrom: 1, HourTo: 5, BaseType: true}.String())
+}
+
+func TestWriteTimeByDomain(t *testing.T) {
+	files := []FileInfo{
+		{Type: "wrfout", Domain: 1, Elapsed: 500 * time.Millisecond},
+		{Type: "wrfout", Domain: 3, Elapsed: 2 * time.Second},
+		{Type: "auxhist23", Domain: 1, Elapsed: 250 * time.Millisecond},
+		{Type: "auxhist23", Domain: 3, Elapsed: time.Second},
+	}
+	assert.Equal(t, map[int]time.Duration{
+		1: 750 * time.Millisecond,
+		3: 3 * time.Second,
+	}, WriteTimeByDomain(files))
+	assert.Equal(t, map[int]time.Duration{}, WriteTimeByDomain(nil))
+}
+
+func TestElapsedRegressions(t *testing.T) {
+	baseline := []FileInfo{
+		{Filename: "wrfout_d01_2021-08-04_00:00:00", Elapsed: time.Second},
+		{Filename: "wrfout_d01_2021-08-04_01:00:00", Elapsed: time.Second},
+		{Filename: "wrfout_d01_2021-08-04_02:00:00", Elapsed: time.Second},
+	}
+	current := []FileInfo{
+		{Filename: "wrfout_d01_2021-08-04_00:00:00", Elapsed: 1400 * time.Millisecond},
+		{Filename: "wrfout_d01_2021-08-04_01:00:00", Elapsed: 2 * time.Second},
+		{Filename: "wrfout_d01_2021-08-04_03:00:00", Elapsed: 10 * time.Second},
+	}
+	assert.Equal(t, []string{
+		"wrfout_d01_2021-08-04_01:00:00 took 2s, more than 1.5 times the 1s of baseline",
+	}, ElapsedRegressions(baseline, current, 1.5))
+	assert.Equal(t, []string{}, ElapsedRegressions(baseline, current, 2))
+}
+
+func TestEstimateCompletion(t *testing.T) {
+	steps := []StepTiming{
+		{Domain: 1, Elapsed: 3 * time.Second},
+		{Domain: 1, Elapsed: time.Second},
+		{Domain: 1, Elapsed: 2 * time.Second},
+	}
+
+	eta, err := EstimateCompletion(steps, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 14*time.Second, eta)
+
+	eta, err = EstimateCompletion(steps, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), eta)
+
+	_, err = EstimateCompletion(steps[:1], 10)
+	assert.EqualError(t, err, "at least 2 step timings are needed, got 1")
+}
+
+func TestParseTrailingDomain(t *testing.T) {
+	cases := map[string]int{
+		"Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds": 1,
+		"Timing for Writing wrfout_d10_2021-08-04_00:00:00 for domain 10:    0.47585 elapsed seconds":       10,
+		"Timing for Writing wrfout_d10_2021-08-04_00:00:00 for domain\t10 :0.47585 elapsed seconds":         10,
+		"Timing for main: time 2021-08-04_00:00:20 on domain   1: for domain  100:0.47585 elapsed seconds":  100,
+	}
+	for line, expected := range cases {
+		domain, err := parseTrailingDomain(line)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, domain, line)
+	}
+
+	_, err := parseTrailingDomain("Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain   :    0.47585 elapsed seconds")
+	assert.Error(t, err)
+	_, err = parseTrailingDomain("Timing for Writing wrfout_d01_2021-08-04_00:00:00")
+	assert.EqualError(t, err, "`for domain` expected to appears in line")
+}
+
+func TestClassifyLine(t *testing.T) {
+	start := "d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated"
+	assert.Equal(t, StartLine, ClassifyLine(start, false))
+	assert.Equal(t, StartLine, ClassifyLine("  "+start, false))
+	assert.Equal(t, OtherLine, ClassifyLine(start, true))
+
+	file := "Timing for Writing wrfout_d01_2021-08-04_00:00:00 for domain        1:    0.47585 elapsed seconds"
+	assert.Equal(t, FileLine, ClassifyLine(file, false))
+	assert.Equal(t, FileLine, ClassifyLine(file, true))
+
+	success := "d01 2021-08-06_00:00:00 wrf: SUCCESS COMPLETE WRF"
+	assert.Equal(t, SuccessLine, ClassifyLine(success, false))
+	assert.Equal(t, SuccessLine, ClassifyLine(success, true))
+
+	step := "Timing for main: time 2021-08-04_00:00:04 on domain   3:    1.60554 elapsed seconds"
+	assert.Equal(t, StepLine, ClassifyLine(step, true))
+
+	assert.Equal(t, AbortLine, ClassifyLine("-------------- FATAL CALLED ---------------", true))
+	assert.Equal(t, OtherLine, ClassifyLine("Quilting with   6 groups of  12 I/O tasks.", true))
+}
+
+func TestParseTimingLine(t *testing.T) {
+	start := time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)
+
+	info, err := ParseTimingLine("Timing for Writing auxhist23_d03_2021-08-04_01:00:00 for domain        3:   10.02259 elapsed seconds", start)
+	assert.NoError(t, err)
+	assert.Equal(t, FileInfo{
+		Type:           "auxhist23",
+		Domain:         3,
+		Instant:        time.Date(2021, 8, 4, 1, 0, 0, 0, time.UTC),
+		HourProgr:      1,
+		Filename:       "auxhist23_d03_2021-08-04_01:00:00",
+		Elapsed:        10022590 * time.Microsecond,
+		ElapsedSeconds: 10.02259,
+	}, info)
+
+	info, err = ParseTimingLine("Timing for Writing restart for domain        1:    1.33332 elapsed seconds", start)
+	assert.NoError(t, err)
+	assert.Equal(t, FileInfo{Type: TypeRestart}, info)
+
+	_, err = ParseTimingLine("Timing for Writing auxhist23_d03_2021-08-RR_01:00:00 for domain        3:   10.02259 elapsed seconds", start)
+	var formatErr *FormatError
+	assert.ErrorAs(t, err, &formatErr)
+
+	_, err = ParseTimingLine("d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF", start)
+	assert.EqualError(t, err, "Wrong format for timing line `d01 2021-08-04_00:00:00 wrf: SUCCESS COMPLETE WRF`: line must start with `Timing for Writing `")
+}
+
+func TestParseVersion(t *testing.T) {
+	file, err := fixtureFS.Open("header-v4")
+	require.NoError(t, err)
+	defer file.Close()
+
+	version, err := ParseVersion(file)
+	require.NoError(t, err)
+	assert.Equal(t, "V4.3.1", version)
+
+	_, err = ParseVersion(strings.NewReader("d01 2021-08-04_00:00:00\nWRF V4.3.1 MODEL\n"))
+	assert.EqualError(t, err, "WRF version not found")
+
+	// the start line is recognized as the parser does,
+	// even when indented.
+	_, err = ParseVersion(strings.NewReader("  d01 2021-08-04_00:00:00\nWRF V4.3.1 MODEL\n"))
+	assert.EqualError(t, err, "WRF version not found")
+}
+
+func TestParseStartLine(t *testing.T) {
+	firstLine := func(name string) string {
+		content, err := fs.ReadFile(fixtureFS, name)
+		require.NoError(t, err)
+		return strings.SplitN(string(content), "\n", 2)[0]
+	}
+
+	start, err := ParseStartLine("d01 2021-08-04_00:00:00  alloc_space_field: domain            2 ,                5403068  bytes allocated")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+	start, err = ParseStartLine(firstLine("indented-start"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC), start)
+
+	_, err = ParseStartLine(firstLine("rsl.out.0000"))
+	var formatErr *FormatError
+	assert.ErrorAs(t, err, &formatErr)
+
+	_, err = ParseStartLine(firstLine("wrong-start-instant"))
+	assert.EqualError(t, err, "Wrong format for start instant line `d01 2021-08-04_00:00:00`: line must contains at leas 3 space separated parts. e.g. `d01 2021-08-04_00:00:00 something`")
+
+	_, err = ParseStartLine(firstLine("wrong-start-instant-format"))
+	assert.EqualError(t, err, "Wrong format for start instant line `d01 2021-08-RR_00:00:00 ciao`: parsing time \"2021-08-RR_00:00:00\" as \"2006-01-02_15:04:05\": cannot parse \"RR_00:00:00\" as \"02\"")
+
+	_, err = ParseStartLine("foo 2021-08-04_00:00:00 bar")
+	assert.EqualError(t, err, "Wrong format for start instant line `foo 2021-08-04_00:00:00 bar`: line must start with `d01 `")
+
+	_, err = ParseStartLine("d01 2021-08-06_00:00:00 wrf: SUCCESS COMPLETE WRF")
+	assert.ErrorAs(t, err, &formatErr)
+}
+
+func TestSetOnStart(t *testing.T) {
+	starts := []time.Time{}
+	parser := parseFixture(t, "rsl.out.0000", func(parser *Parser) {
+		parser.SetOnStart(func(start time.Time) {
+			starts = append(starts, start)
+		})
+	})
+	_, err := parser.Collect()
+	require.NoError(t, err)
+
+	assert.Equal(t, []time.Time{time.Date(2021, 8, 4, 0, 0, 0, 0, time.UTC)}, starts)
+}
+
+func TestSetDomainMapper(t *testing.T) {
+	parser := parseFixture(t, "rsl.out.0000", func(parser *Parser) {
+		parser.SetDomainMapper(func(domain int) int {
+			if domain == 3 {
+				return 30
+			}
+			return domain
+		})
+	})
+
+	mapped := 0
+	err := parser.OnFileDo("", 3, func(info FileInfo) error {
+		t.Errorf("unexpected file of domain 3: %s", info.Filename)
+		return nil
+	}).OnFileDo("", 30, func(info FileInfo) error {
+		assert.Contains(t, info.Filename, "_d03_")
+		mapped++
+		return nil
+	}).Execute()
+	require.NoError(t, err)
+	assert.Equal(t, 147, mapped)
+}
+
+// fixtureFS contains the WRF logs shared with
+// the tests of the helpers package.
+var fixtureFS = os.DirFS(filepath.Join("helpers", "fixtures"))
+
+// parseFixture starts parsing the named fixture with a
+// parser configured by opts, and returns the parser.
+func parseFixture(t *testing.T, name string, opts ...func(*Parser)) *Parser {
+	file, err := fixtureFS.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+
+	parser := NewParser(100 * time.Millisecond)
+	for _, opt := range opts {
+		opt(parser)
+	}
+	go parser.Parse(file)
+	return parser
+}